@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"time"
 
@@ -12,50 +13,78 @@ import (
 	"github.com/matthisholleville/mapsyncproxy/api/client"
 	"github.com/matthisholleville/mapsyncproxy/api/handlers"
 	v1 "github.com/matthisholleville/mapsyncproxy/api/v1"
+	"github.com/matthisholleville/mapsyncproxy/pkg/expiry"
 	"github.com/matthisholleville/mapsyncproxy/pkg/gcs"
-	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
 	"github.com/matthisholleville/mapsyncproxy/pkg/metrics"
+	"github.com/matthisholleville/mapsyncproxy/pkg/overrides"
+	"github.com/matthisholleville/mapsyncproxy/pkg/status"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	echoSwagger "github.com/swaggo/echo-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
 )
 
 func Server(ctx context.Context, sigs chan os.Signal) {
+	client.SetDefaults()
 
-	viper.AutomaticEnv()
-	viper.SetEnvPrefix("MAPSYNCPROXY")
-	viper.SetDefault("DATAPLANE_USERNAME", "admin")
-	viper.SetDefault("DATAPLANE_PASSWORD", "adminpwd")
-	viper.SetDefault("DATAPLANE_HOST", "127.0.0.1:5555")
-
-	dataplaneApiHost := viper.GetString("DATAPLANE_HOST")
-	log.Debug().Msgf("Listening to HAProxy Dataplane API on %s", dataplaneApiHost)
+	shutdownTracing := client.SetupTracing(ctx)
+	gcsClient := gcs.NewClient()
+	serverMetrics := metrics.New()
 
 	s := &client.MapSyncProxyAPI{
-		Echo: echo.New(),
-		HAProxyClient: haproxy.NewClient(
-			viper.GetString("DATAPLANE_USERNAME"),
-			viper.GetString("DATAPLANE_PASSWORD"),
-			viper.GetString("DATAPLANE_HOST"),
-			true,
-		),
-		GCSClientWrapper: gcs.NewClient(),
-		ServerMetrics:    metrics.New(),
+		Echo:              echo.New(),
+		HAProxyClient:     client.NewHAProxyBackend(serverMetrics),
+		GCSClientWrapper:  gcsClient,
+		ServerMetrics:     serverMetrics,
+		SignatureVerifier: client.NewSignatureVerifier(),
+		HistoryStore:      client.NewHistoryStore(gcsClient),
+		AuditLogger:       client.NewAuditLogger(),
+		ManualEntries:     overrides.NewRegistry(),
+		Expirations:       expiry.NewSchedule(),
+		SyncStatuses:      status.NewTracker(),
+		AuditCallerHeader: viper.GetString("AUDIT_CALLER_HEADER"),
+		SyncTimeouts:      client.NewPhaseTimeouts(),
+		SourceLimits:      client.NewSourceLimits(),
+	}
+
+	// The manual changes and the expirations are kept next to the map
+	// history, so that the entries still expire after a restart.
+	if s.HistoryStore != nil {
+		if err := s.ManualEntries.Persist(s.HistoryStore); err != nil {
+			log.Fatal().Err(err).Msg("The manual changes could not be loaded.")
+		}
+		if err := s.Expirations.Persist(s.HistoryStore); err != nil {
+			log.Fatal().Err(err).Msg("The expirations could not be loaded.")
+		}
 	}
 
 	s.Echo.HideBanner = true
 
+	s.Echo.Use(middleware.RequestID())
 	s.Echo.Use(middleware.Logger())
 	//CORS
 	s.Echo.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
 		AllowMethods: []string{echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
 	}))
+	//TRACING
+	s.Echo.Use(otelecho.Middleware("mapsyncproxy", otelecho.WithSkipper(func(c echo.Context) bool {
+		switch c.Path() {
+		case "/metrics", "/healthz", "/readyz", "/swagger/*":
+			return true
+		}
+		return false
+	})))
 	//PROMETHEUS
-	s.Echo.Use(echoprometheus.NewMiddleware("mapsyncproxy"))
+	s.Echo.Use(echoprometheus.NewMiddlewareWithConfig(echoprometheus.MiddlewareConfig{
+		Subsystem:  "mapsyncproxy",
+		Registerer: s.ServerMetrics.Registry,
+	}))
 
 	s.Echo.GET("/swagger/*", echoSwagger.WrapHandler)
-	s.Echo.GET("/metrics", echoprometheus.NewHandler())
+	s.Echo.GET("/metrics", echoprometheus.NewHandlerWithConfig(echoprometheus.HandlerConfig{
+		Gatherer: s.ServerMetrics.Registry,
+	}))
 	s.Echo.GET("/healthz", handlers.Health)
 	s.Echo.GET("/readyz", handlers.Ready)
 
@@ -66,6 +95,18 @@ func Server(ctx context.Context, sigs chan os.Signal) {
 		port = "8080"
 	}
 
+	expiryCtx, stopExpiry := context.WithCancel(ctx)
+	defer stopExpiry()
+	go expireEntries(expiryCtx, s)
+
+	// Requests are canceled on shutdown, so that in-flight synchronizations
+	// stop cleanly before the server exits.
+	requestCtx, cancelRequests := context.WithCancel(ctx)
+	defer cancelRequests()
+	s.Echo.Server.BaseContext = func(net.Listener) context.Context {
+		return requestCtx
+	}
+
 	go func() {
 		err := s.Echo.Start(fmt.Sprintf(":%s", port))
 		if err != nil {
@@ -76,6 +117,8 @@ func Server(ctx context.Context, sigs chan os.Signal) {
 	// wait for signals to shutdown
 	<-sigs
 	log.Info().Msg("shutting down the API server")
+	cancelRequests()
+	stopExpiry()
 
 	ctxTimeout, cancel := context.WithTimeout(ctx, contextTimeout*time.Second)
 
@@ -85,4 +128,27 @@ func Server(ctx context.Context, sigs chan os.Signal) {
 		s.Echo.Logger.Fatal(err)
 	}
 
+	if err := s.AuditLogger.Close(ctxTimeout); err != nil {
+		log.Warn().Err(err).Msg("The pending audit events could not be delivered.")
+	}
+
+	if err := shutdownTracing(ctxTimeout); err != nil {
+		log.Warn().Err(err).Msg("The pending spans could not be exported.")
+	}
+
+}
+
+// expireEntries removes the expired entries until ctx is done.
+func expireEntries(ctx context.Context, s *client.MapSyncProxyAPI) {
+	ticker := time.NewTicker(expiryInterval * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			handlers.ExpireEntries(ctx, s)
+		}
+	}
 }
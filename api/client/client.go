@@ -1,37 +1,293 @@
 package client
 
 import (
+	"context"
+	"strings"
+	"time"
+
 	"github.com/labstack/echo/v4"
+	"github.com/matthisholleville/mapsyncproxy/pkg/audit"
+	"github.com/matthisholleville/mapsyncproxy/pkg/expiry"
 	"github.com/matthisholleville/mapsyncproxy/pkg/gcs"
 	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/history"
 	"github.com/matthisholleville/mapsyncproxy/pkg/metrics"
+	"github.com/matthisholleville/mapsyncproxy/pkg/overrides"
+	"github.com/matthisholleville/mapsyncproxy/pkg/signature"
+	"github.com/matthisholleville/mapsyncproxy/pkg/status"
+	"github.com/matthisholleville/mapsyncproxy/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 )
 
 type MapSyncProxyAPI struct {
 	Echo             *echo.Echo
-	HAProxyClient    *haproxy.Client
-	GCSClientWrapper *gcs.GCSClientWrapper
+	HAProxyClient    haproxy.MapBackend
+	GCSClientWrapper gcs.Storage
 	ServerMetrics    *metrics.ServerMetrics
+	// SignatureVerifier is nil when no signature public key is configured.
+	SignatureVerifier *signature.Verifier
+	// HistoryStore is nil when the map history is disabled.
+	HistoryStore *history.Store
+	AuditLogger  *audit.Logger
+	// AuditCallerHeader is the request header holding the caller identity.
+	// It is empty unless a trusted proxy sets it, since callers can send any
+	// header.
+	AuditCallerHeader string
+	// ManualEntries records the entries changed through the entries API.
+	ManualEntries *overrides.Registry
+	// Expirations schedules the removal of synchronized entries with an expiry date.
+	Expirations *expiry.Schedule
+	// SyncStatuses holds the last synchronization status of each map.
+	SyncStatuses *status.Tracker
+	// SyncTimeouts bounds each phase of a synchronization.
+	SyncTimeouts PhaseTimeouts
+	// SourceLimits bounds the size of the source files.
+	SourceLimits SourceLimits
+}
+
+// PhaseTimeouts bounds the download of the source files, the diff against the
+// live map and the application of the changes. Zero disables a deadline.
+type PhaseTimeouts struct {
+	Download time.Duration
+	Diff     time.Duration
+	Apply    time.Duration
+}
+
+// SourceLimits bounds the size in bytes of a source file as stored and, when
+// it is compressed, once decompressed. Zero disables a limit.
+type SourceLimits struct {
+	MaxSize             int64
+	MaxDecompressedSize int64
 }
 
 func New() *MapSyncProxyAPI {
+	SetDefaults()
+
+	gcsClient := gcs.NewClient()
+	serverMetrics := metrics.New()
 
+	return &MapSyncProxyAPI{
+		Echo:              echo.New(),
+		HAProxyClient:     NewHAProxyBackend(serverMetrics),
+		GCSClientWrapper:  gcsClient,
+		ServerMetrics:     serverMetrics,
+		SignatureVerifier: NewSignatureVerifier(),
+		HistoryStore:      NewHistoryStore(gcsClient),
+		AuditLogger:       NewAuditLogger(),
+		ManualEntries:     overrides.NewRegistry(),
+		Expirations:       expiry.NewSchedule(),
+		SyncStatuses:      status.NewTracker(),
+		AuditCallerHeader: viper.GetString("AUDIT_CALLER_HEADER"),
+		SyncTimeouts:      NewPhaseTimeouts(),
+		SourceLimits:      NewSourceLimits(),
+	}
+}
+
+// SetDefaults reads the configuration from the MAPSYNCPROXY_ environment
+// variables and sets the default of each setting.
+func SetDefaults() {
 	viper.AutomaticEnv()
 	viper.SetEnvPrefix("MAPSYNCPROXY")
-	viper.SetDefault("DATAPLANE_USERNAME", "admin")
-	viper.SetDefault("DATAPLANE_PASSWORD", "adminpwd")
+	viper.SetDefault("DATAPLANE_USERNAME", "")
+	viper.SetDefault("DATAPLANE_PASSWORD", "")
+	viper.SetDefault("DATAPLANE_USERNAME_FILE", "")
+	viper.SetDefault("DATAPLANE_PASSWORD_FILE", "")
 	viper.SetDefault("DATAPLANE_HOST", "127.0.0.1:5555")
+	viper.SetDefault("DATAPLANE_SCHEME", "http")
+	viper.SetDefault("DATAPLANE_API_VERSION", "auto")
+	viper.SetDefault("DATAPLANE_CA_FILE", "")
+	viper.SetDefault("DATAPLANE_CERT_FILE", "")
+	viper.SetDefault("DATAPLANE_KEY_FILE", "")
+	viper.SetDefault("DATAPLANE_SERVER_NAME", "")
+	viper.SetDefault("DATAPLANE_TLS_MIN_VERSION", "1.2")
+	viper.SetDefault("HAPROXY_BACKEND", "dataplane")
+	viper.SetDefault("RUNTIME_API_ADDRESS", "/var/run/api.sock")
+	viper.SetDefault("SIGNATURE_PUBLIC_KEY_FILE", "")
+	viper.SetDefault("HISTORY_DIR", "")
+	viper.SetDefault("HISTORY_BUCKET", "")
+	viper.SetDefault("HISTORY_BUCKET_PREFIX", "history/")
+	viper.SetDefault("HISTORY_MAX_REVISIONS", 100)
+	viper.SetDefault("HISTORY_MAX_AGE", "0s")
+	viper.SetDefault("AUDIT_SINKS", "")
+	viper.SetDefault("AUDIT_FILE", "audit.jsonl")
+	viper.SetDefault("AUDIT_WEBHOOK_URL", "")
+	viper.SetDefault("AUDIT_RETAINED_EVENTS", 10000)
+	viper.SetDefault("AUDIT_WEBHOOK_QUEUE_SIZE", audit.DefaultWebhookQueueSize)
+	viper.SetDefault("AUDIT_CALLER_HEADER", "")
+	viper.SetDefault("SYNC_DOWNLOAD_TIMEOUT", "2m")
+	viper.SetDefault("SYNC_DIFF_TIMEOUT", "2m")
+	viper.SetDefault("SYNC_APPLY_TIMEOUT", "10m")
+	viper.SetDefault("SOURCE_MAX_SIZE", 64<<20)
+	viper.SetDefault("SOURCE_MAX_DECOMPRESSED_SIZE", 256<<20)
+	viper.SetDefault("TRACING_EXPORTER", "none")
+	viper.SetDefault("TRACING_OTLP_ENDPOINT", "")
+	viper.SetDefault("TRACING_OTLP_INSECURE", false)
+	viper.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+}
 
-	return &MapSyncProxyAPI{
-		Echo: echo.New(),
-		HAProxyClient: haproxy.NewClient(
-			viper.GetString("DATAPLANE_USERNAME"),
-			viper.GetString("DATAPLANE_PASSWORD"),
+// NewPhaseTimeouts reads the synchronization phase deadlines configured with
+// MAPSYNCPROXY_SYNC_DOWNLOAD_TIMEOUT, MAPSYNCPROXY_SYNC_DIFF_TIMEOUT and
+// MAPSYNCPROXY_SYNC_APPLY_TIMEOUT.
+func NewPhaseTimeouts() PhaseTimeouts {
+	return PhaseTimeouts{
+		Download: viper.GetDuration("SYNC_DOWNLOAD_TIMEOUT"),
+		Diff:     viper.GetDuration("SYNC_DIFF_TIMEOUT"),
+		Apply:    viper.GetDuration("SYNC_APPLY_TIMEOUT"),
+	}
+}
+
+// NewSourceLimits reads the source file size limits configured with
+// MAPSYNCPROXY_SOURCE_MAX_SIZE and MAPSYNCPROXY_SOURCE_MAX_DECOMPRESSED_SIZE.
+func NewSourceLimits() SourceLimits {
+	return SourceLimits{
+		MaxSize:             viper.GetInt64("SOURCE_MAX_SIZE"),
+		MaxDecompressedSize: viper.GetInt64("SOURCE_MAX_DECOMPRESSED_SIZE"),
+	}
+}
+
+// SetupTracing installs the tracer provider configured with
+// MAPSYNCPROXY_TRACING_EXPORTER, MAPSYNCPROXY_TRACING_OTLP_ENDPOINT,
+// MAPSYNCPROXY_TRACING_OTLP_INSECURE and MAPSYNCPROXY_TRACING_SAMPLE_RATIO, and
+// returns the function flushing the spans on shutdown.
+func SetupTracing(ctx context.Context) func(context.Context) error {
+	shutdown, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:     viper.GetString("TRACING_EXPORTER"),
+		OTLPEndpoint: viper.GetString("TRACING_OTLP_ENDPOINT"),
+		OTLPInsecure: viper.GetBool("TRACING_OTLP_INSECURE"),
+		SampleRatio:  viper.GetFloat64("TRACING_SAMPLE_RATIO"),
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("The tracing could not be configured.")
+	}
+	return shutdown
+}
+
+// NewHAProxyBackend returns the HAProxy backend selected with
+// MAPSYNCPROXY_HAPROXY_BACKEND: the Dataplane API (dataplane) or the Runtime
+// API stats socket (runtime). The latency of the Dataplane API requests is
+// recorded in serverMetrics.
+func NewHAProxyBackend(serverMetrics *metrics.ServerMetrics) haproxy.MapBackend {
+	switch backend := viper.GetString("HAPROXY_BACKEND"); backend {
+	case "dataplane":
+		log.Debug().Msgf("Listening to HAProxy Dataplane API on %s", viper.GetString("DATAPLANE_HOST"))
+		dataplaneClient, err := haproxy.NewClient(
+			newDataplaneCredentials(viper.GetString("DATAPLANE_HOST")),
 			viper.GetString("DATAPLANE_HOST"),
-			true,
-		),
-		GCSClientWrapper: gcs.NewClient(),
-		ServerMetrics:    metrics.New(),
+			newDataplaneTLSConfig(),
+			viper.GetString("DATAPLANE_API_VERSION"),
+		)
+		if err != nil {
+			log.Fatal().Err(err).Msg("The Dataplane API client could not be configured.")
+		}
+		dataplaneClient.SetRequestObserver(func(operation, status string, duration time.Duration) {
+			serverMetrics.DataplaneRequestDuration.With(prometheus.Labels{"operation": operation, "status": status}).Observe(duration.Seconds())
+		})
+		return dataplaneClient
+	case "runtime":
+		log.Debug().Msgf("Listening to HAProxy Runtime API on %s", viper.GetString("RUNTIME_API_ADDRESS"))
+		return haproxy.NewSocketClient(viper.GetString("RUNTIME_API_ADDRESS"))
+	default:
+		log.Fatal().Msgf("Unknown HAProxy backend '%s'.", backend)
+		return nil
+	}
+}
+
+// newDataplaneCredentials returns the Dataplane API credentials, read from
+// MAPSYNCPROXY_DATAPLANE_USERNAME_FILE and MAPSYNCPROXY_DATAPLANE_PASSWORD_FILE,
+// or from MAPSYNCPROXY_DATAPLANE_USERNAME and MAPSYNCPROXY_DATAPLANE_PASSWORD.
+// The startup fails when the credentials of host are missing.
+func newDataplaneCredentials(host string) haproxy.Credentials {
+	var credentials haproxy.Credentials
+	switch {
+	case viper.GetString("DATAPLANE_USERNAME_FILE") != "" || viper.GetString("DATAPLANE_PASSWORD_FILE") != "":
+		credentials = haproxy.FileCredentials(viper.GetString("DATAPLANE_USERNAME_FILE"), viper.GetString("DATAPLANE_PASSWORD_FILE"))
+	default:
+		credentials = haproxy.StaticCredentials(viper.GetString("DATAPLANE_USERNAME"), viper.GetString("DATAPLANE_PASSWORD"))
 	}
+
+	if err := haproxy.CheckCredentials(credentials, host); err != nil {
+		log.Fatal().Err(err).Msgf("No usable Dataplane API credentials are configured for %s.", host)
+	}
+	return credentials
+}
+
+// newDataplaneTLSConfig returns the TLS configuration of the Dataplane API
+// connection, or nil when MAPSYNCPROXY_DATAPLANE_SCHEME is http.
+func newDataplaneTLSConfig() *haproxy.TLSConfig {
+	switch scheme := viper.GetString("DATAPLANE_SCHEME"); scheme {
+	case "http":
+		return nil
+	case "https":
+	default:
+		log.Fatal().Msgf("Unknown Dataplane API scheme '%s'.", scheme)
+	}
+
+	minVersion, err := haproxy.ParseTLSVersion(viper.GetString("DATAPLANE_TLS_MIN_VERSION"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("The Dataplane TLS minimum version is invalid.")
+	}
+	return &haproxy.TLSConfig{
+		CAFile:     viper.GetString("DATAPLANE_CA_FILE"),
+		CertFile:   viper.GetString("DATAPLANE_CERT_FILE"),
+		KeyFile:    viper.GetString("DATAPLANE_KEY_FILE"),
+		ServerName: viper.GetString("DATAPLANE_SERVER_NAME"),
+		MinVersion: minVersion,
+	}
+}
+
+// NewSignatureVerifier loads the public key configured with
+// MAPSYNCPROXY_SIGNATURE_PUBLIC_KEY_FILE, if any.
+func NewSignatureVerifier() *signature.Verifier {
+	publicKeyFile := viper.GetString("SIGNATURE_PUBLIC_KEY_FILE")
+	if publicKeyFile == "" {
+		return nil
+	}
+
+	verifier, err := signature.NewVerifier(publicKeyFile)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("The signature public key %s could not be loaded.", publicKeyFile)
+	}
+	return verifier
+}
+
+// NewHistoryStore returns the map history store configured with
+// MAPSYNCPROXY_HISTORY_BUCKET or MAPSYNCPROXY_HISTORY_DIR, if any.
+func NewHistoryStore(gcsClient gcs.Storage) *history.Store {
+	retention := history.Retention{
+		MaxRevisions: viper.GetInt("HISTORY_MAX_REVISIONS"),
+		MaxAge:       viper.GetDuration("HISTORY_MAX_AGE"),
+	}
+
+	if bucket := viper.GetString("HISTORY_BUCKET"); bucket != "" {
+		log.Info().Msgf("Map history is stored in the %s bucket.", bucket)
+		return history.NewGCSStore(gcsClient, bucket, viper.GetString("HISTORY_BUCKET_PREFIX"), retention)
+	}
+	if dir := viper.GetString("HISTORY_DIR"); dir != "" {
+		log.Info().Msgf("Map history is stored in %s.", dir)
+		return history.NewLocalStore(dir, retention)
+	}
+	return nil
+}
+
+// NewAuditLogger returns the audit logger writing to the sinks listed in
+// MAPSYNCPROXY_AUDIT_SINKS (stdout, file, webhook).
+func NewAuditLogger() *audit.Logger {
+	sinks := []audit.Sink{}
+	for _, name := range strings.Split(viper.GetString("AUDIT_SINKS"), ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "stdout":
+			sinks = append(sinks, audit.NewStdoutSink())
+		case "file":
+			sinks = append(sinks, audit.NewFileSink(viper.GetString("AUDIT_FILE")))
+		case "webhook":
+			sinks = append(sinks, audit.NewWebhookSink(viper.GetString("AUDIT_WEBHOOK_URL"), viper.GetInt("AUDIT_WEBHOOK_QUEUE_SIZE")))
+		default:
+			log.Fatal().Msgf("Unknown audit sink '%s'.", name)
+		}
+	}
+
+	return audit.New(viper.GetInt("AUDIT_RETAINED_EVENTS"), sinks...)
 }
@@ -2,4 +2,5 @@ package api
 
 const (
 	contextTimeout = 10
+	expiryInterval = 10
 )
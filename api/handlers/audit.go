@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/matthisholleville/mapsyncproxy/api/client"
+	"github.com/matthisholleville/mapsyncproxy/pkg/audit"
+	"github.com/rs/zerolog/log"
+)
+
+// unknownCaller identifies a caller whose address cannot be read.
+const unknownCaller = "unknown"
+
+// auditTrail collects the audit events of a request.
+type auditTrail struct {
+	caller    string
+	requestID string
+	// source is used for keys missing from owners.
+	source      string
+	generation  int64
+	owners      map[string]string
+	generations map[string]int64
+	events      []audit.Event
+}
+
+func newAuditTrail(c echo.Context, mapSyncContext *client.MapSyncProxyAPI) *auditTrail {
+	return &auditTrail{
+		caller:    auditCaller(c, mapSyncContext.AuditCallerHeader),
+		requestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	}
+}
+
+// auditCaller returns the caller identity from the configured header, which
+// only a trusted proxy may set. Without it, the caller is identified by the
+// remote address of the connection, since any other request header can be
+// forged.
+func auditCaller(c echo.Context, header string) string {
+	if header != "" {
+		if caller := c.Request().Header.Get(header); caller != "" {
+			return caller
+		}
+	}
+
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil || host == "" {
+		return unknownCaller
+	}
+	return host
+}
+
+// setSources records the files the desired entries were read from. A nil
+// owners map means every entry comes from the first file.
+func (t *auditTrail) setSources(files []sourceFile, owners map[string]string) {
+	t.owners = owners
+	t.generations = make(map[string]int64)
+	for _, file := range files {
+		t.generations[file.Name] = file.Generation
+	}
+	if len(files) > 0 && owners == nil {
+		t.source = files[0].Name
+		t.generation = files[0].Generation
+	}
+}
+
+func (t *auditTrail) add(action, mapName, key, oldValue, newValue string) {
+	source, generation := t.source, t.generation
+	if owner, exists := t.owners[key]; exists {
+		source, generation = owner, t.generations[owner]
+	}
+
+	t.events = append(t.events, audit.Event{
+		Caller:     t.caller,
+		RequestID:  t.requestID,
+		MapName:    mapName,
+		Action:     action,
+		Key:        key,
+		OldValue:   oldValue,
+		NewValue:   newValue,
+		Source:     source,
+		Generation: generation,
+	})
+}
+
+func (t *auditTrail) flush(mapSyncContext *client.MapSyncProxyAPI) {
+	mapSyncContext.AuditLogger.Record(t.events)
+	t.events = nil
+}
+
+// ListAuditEvents godoc
+//
+//	@Tags			Audit
+//	@Summary		List the audit events of map mutations.
+//	@Description	List the audit events of map mutations, oldest first.
+//	@Accept			json
+//	@Produce		json
+//	@Param		map_name	query	string				false	"Map name"//
+//	@Param		key			query	string				false	"Entry key"//
+//	@Param		caller		query	string				false	"Caller identity"//
+//	@Param		action		query	string				false	"create, update or delete"//
+//	@Param		request_id	query	string				false	"Request id"//
+//	@Param		since		query	string				false	"RFC 3339 lower time bound"//
+//	@Param		until		query	string				false	"RFC 3339 upper time bound"//
+//	@Param		limit		query	int					false	"Maximum number of events, the most recent are kept"//
+//
+// @Success		200	{array}	audit.Event
+// @Failure		400		"Invalid filter"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/audit [get]
+func ListAuditEvents(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+
+	filter := audit.Filter{
+		MapName:   c.QueryParam("map_name"),
+		Key:       c.QueryParam("key"),
+		Caller:    c.QueryParam("caller"),
+		Action:    c.QueryParam("action"),
+		RequestID: c.QueryParam("request_id"),
+	}
+
+	if since := c.QueryParam("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonResponse("'since' must be an RFC 3339 date."))
+		}
+	}
+	if until := c.QueryParam("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonResponse("'until' must be an RFC 3339 date."))
+		}
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return c.JSON(http.StatusBadRequest, jsonResponse("'limit' must be a positive integer."))
+		}
+	}
+
+	// The events are streamed as they are read. The response starts with the
+	// first event, so that an early failure still answers an error.
+	response := c.Response()
+	encoder := json.NewEncoder(response)
+	err = mapSyncContext.AuditLogger.Query(filter, func(event audit.Event) error {
+		separator := ","
+		if !response.Committed {
+			response.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+			response.WriteHeader(http.StatusOK)
+			separator = "["
+		}
+		if _, err := response.Write([]byte(separator)); err != nil {
+			return err
+		}
+		return encoder.Encode(event)
+	})
+	if err != nil && !response.Committed {
+		log.Debug().Err(err).Msg("The audit events could not be retrieved.")
+		return c.JSON(http.StatusInternalServerError, jsonResponse("The audit events could not be retrieved."))
+	}
+	if err != nil {
+		// The status is already sent, the truncated array tells the caller.
+		log.Warn().Err(err).Msg("The audit events could not be streamed.")
+		return nil
+	}
+	if !response.Committed {
+		return c.JSON(http.StatusOK, []audit.Event{})
+	}
+	_, err = response.Write([]byte("]\n"))
+	return err
+}
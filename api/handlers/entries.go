@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/matthisholleville/mapsyncproxy/api/client"
+	"github.com/matthisholleville/mapsyncproxy/pkg/audit"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/overrides"
+	"github.com/rs/zerolog/log"
+)
+
+// GetMapEntrie godoc
+//
+//	@Tags			Entries
+//	@Summary		Get a map entry.
+//	@Description	Get a map entry. The key must be URL encoded.
+//	@Accept			json
+//	@Produce		json
+//	@Param		map_name	path	string				true	"Map name"//
+//	@Param		key			path	string				true	"URL encoded key"//
+//
+// @Success		200	{object}	haproxy.MapEntrie
+// @Failure		400		"Invalid map name or key"
+// @Failure		404		"Entry not found"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/map/{map_name}/entries/{key} [get]
+func GetMapEntrie(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+
+	mapName, key, err := entrieParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, jsonResponse(err.Error()))
+	}
+
+	entrie, err := mapSyncContext.HAProxyClient.GetMapEntrie(c.Request().Context(), key, mapName)
+	if errors.Is(err, haproxy.ErrMapEntrieNotFound) {
+		return c.JSON(http.StatusNotFound, jsonResponse(fmt.Sprintf("The '%s' entry does not exist.", key)))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("The '%s' entry could not be retrieved.", key)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be retrieved.", key)))
+	}
+
+	return c.JSON(http.StatusOK, entrie)
+}
+
+// CreateMapEntrie godoc
+//
+//	@Tags			Entries
+//	@Summary		Create a map entry.
+//	@Description	Create a map entry, kept until the next synchronization unless it is preserved.
+//	@Accept			json
+//	@Produce		json
+//	@Param		_			body	MapEntrieRequestBody	true	"Entry to create"
+//	@Param		map_name	path	string				true	"Map name"//
+//
+// @Success		201	{object}	haproxy.MapEntrie
+// @Failure		400		"Invalid request body"
+// @Failure		409		"Entry already exists"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/map/{map_name}/entries [post]
+func CreateMapEntrie(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+	mapName, err := mapNameParam(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, jsonResponse(err.Error()))
+	}
+
+	requestBody, expiresAt, err := bindMapEntrie(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, jsonResponse(err.Error()))
+	}
+	if requestBody.Key == "" {
+		return c.JSON(http.StatusBadRequest, jsonResponse("'key' cannot be empty."))
+	}
+
+	entrie := haproxy.MapEntrie{Key: requestBody.Key, Value: requestBody.Value}
+	_, err = mapSyncContext.HAProxyClient.CreateMapEntrie(c.Request().Context(), &entrie, mapName)
+	if errors.Is(err, haproxy.ErrMapEntrieAlreadyExists) {
+		return c.JSON(http.StatusConflict, jsonResponse(fmt.Sprintf("The '%s' entry already exists.", entrie.Key)))
+	}
+	if errors.Is(err, haproxy.ErrInvalidMapEntrie) {
+		return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Invalid entry: %s.", err)))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("The '%s' entry could not be created.", entrie.Key)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be created.", entrie.Key)))
+	}
+	mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("created", mapName)).Inc()
+
+	trail := newAuditTrail(c, mapSyncContext)
+	recordManualChange(mapSyncContext, trail, overrides.Change{
+		MapName:   mapName,
+		Key:       entrie.Key,
+		Value:     entrie.Value,
+		ExpiresAt: expiresAt,
+	})
+
+	return c.JSON(http.StatusCreated, entrie)
+}
+
+// UpdateMapEntrie godoc
+//
+//	@Tags			Entries
+//	@Summary		Update a map entry.
+//	@Description	Update a map entry. With a TTL, the previous value is restored once it elapses.
+//	@Accept			json
+//	@Produce		json
+//	@Param		_			body	MapEntrieRequestBody	true	"New value of the entry"
+//	@Param		map_name	path	string				true	"Map name"//
+//	@Param		key			path	string				true	"URL encoded key"//
+//
+// @Success		200	{object}	haproxy.MapEntrie
+// @Failure		400		"Invalid request body"
+// @Failure		404		"Entry not found"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/map/{map_name}/entries/{key} [put]
+func UpdateMapEntrie(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+
+	mapName, key, err := entrieParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, jsonResponse(err.Error()))
+	}
+
+	requestBody, expiresAt, err := bindMapEntrie(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, jsonResponse(err.Error()))
+	}
+	if requestBody.Key != "" && requestBody.Key != key {
+		return c.JSON(http.StatusBadRequest, jsonResponse("The body key does not match the path key."))
+	}
+
+	existing, err := mapSyncContext.HAProxyClient.GetMapEntrie(c.Request().Context(), key, mapName)
+	if errors.Is(err, haproxy.ErrMapEntrieNotFound) {
+		return c.JSON(http.StatusNotFound, jsonResponse(fmt.Sprintf("The '%s' entry does not exist.", key)))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("The '%s' entry could not be retrieved.", key)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be retrieved.", key)))
+	}
+
+	entrie := haproxy.MapEntrie{Key: key, Value: requestBody.Value}
+	_, err = mapSyncContext.HAProxyClient.UpdateMapEntrie(c.Request().Context(), &entrie, mapName)
+	if errors.Is(err, haproxy.ErrInvalidMapEntrie) {
+		return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Invalid entry: %s.", err)))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("The '%s' entry could not be updated.", key)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be updated.", key)))
+	}
+	mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("updated", mapName)).Inc()
+
+	trail := newAuditTrail(c, mapSyncContext)
+	recordManualChange(mapSyncContext, trail, overrides.Change{
+		MapName:       mapName,
+		Key:           key,
+		Value:         entrie.Value,
+		PreviousValue: existing.Value,
+		HadPrevious:   true,
+		ExpiresAt:     expiresAt,
+	})
+
+	return c.JSON(http.StatusOK, entrie)
+}
+
+// DeleteMapEntrie godoc
+//
+//	@Tags			Entries
+//	@Summary		Delete a map entry.
+//	@Description	Delete a map entry. The key must be URL encoded.
+//	@Accept			json
+//	@Produce		json
+//	@Param		map_name	path	string				true	"Map name"//
+//	@Param		key			path	string				true	"URL encoded key"//
+//
+// @Success		204
+// @Failure		400		"Invalid map name or key"
+// @Failure		404		"Entry not found"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/map/{map_name}/entries/{key} [delete]
+func DeleteMapEntrie(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+
+	mapName, key, err := entrieParams(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, jsonResponse(err.Error()))
+	}
+
+	existing, err := mapSyncContext.HAProxyClient.GetMapEntrie(c.Request().Context(), key, mapName)
+	if errors.Is(err, haproxy.ErrMapEntrieNotFound) {
+		return c.JSON(http.StatusNotFound, jsonResponse(fmt.Sprintf("The '%s' entry does not exist.", key)))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("The '%s' entry could not be retrieved.", key)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be retrieved.", key)))
+	}
+
+	if _, err = mapSyncContext.HAProxyClient.DeleteMapEntrie(c.Request().Context(), existing, mapName); err != nil {
+		log.Debug().Err(err).Msgf("The '%s' entry could not be deleted.", key)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be deleted.", key)))
+	}
+	mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("deleted", mapName)).Inc()
+
+	trail := newAuditTrail(c, mapSyncContext)
+	recordManualChange(mapSyncContext, trail, overrides.Change{
+		MapName:       mapName,
+		Key:           key,
+		Deleted:       true,
+		PreviousValue: existing.Value,
+		HadPrevious:   true,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ExpireEntries removes the synchronized entries whose expiry date is reached,
+// and reverts the manual changes whose TTL elapsed: the previous value is
+// restored, or the entry is deleted when it had none.
+func ExpireEntries(ctx context.Context, mapSyncContext *client.MapSyncProxyAPI) {
+	now := time.Now()
+	affectedMaps := make(map[string]bool)
+
+	trail := &auditTrail{caller: expiryCaller, source: expirySource}
+	defer trail.flush(mapSyncContext)
+
+	for _, expiration := range mapSyncContext.Expirations.Expired(now) {
+		// An entry changed since it was synchronized is left alone.
+		live, err := mapSyncContext.HAProxyClient.GetMapEntrie(ctx, expiration.Key, expiration.MapName)
+		if errors.Is(err, haproxy.ErrMapEntrieNotFound) || (err == nil && live.Value != expiration.Value) {
+			log.Info().Msgf("The '%s' entry of the '%s' map changed since its synchronization and is not expired.", expiration.Key, expiration.MapName)
+			mapSyncContext.Expirations.Remove(expiration.MapName, expiration.Key)
+			affectedMaps[expiration.MapName] = true
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("The '%s' entry of the '%s' map could not be retrieved.", expiration.Key, expiration.MapName)
+			continue
+		}
+		_, err = mapSyncContext.HAProxyClient.DeleteMapEntrie(ctx, live, expiration.MapName)
+		if err != nil && !errors.Is(err, haproxy.ErrMapEntrieNotFound) {
+			log.Error().Err(err).Msgf("The '%s' entry of the '%s' map could not be deleted.", expiration.Key, expiration.MapName)
+			continue
+		}
+		trail.add(audit.ActionDelete, expiration.MapName, expiration.Key, expiration.Value, "")
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("deleted", expiration.MapName)).Inc()
+		mapSyncContext.Expirations.Remove(expiration.MapName, expiration.Key)
+		affectedMaps[expiration.MapName] = true
+		log.Info().Msgf("The '%s' entry of the '%s' map expired.", expiration.Key, expiration.MapName)
+	}
+
+	trail.source = manualSource
+	for _, change := range mapSyncContext.ManualEntries.Expired(now) {
+		entrie := haproxy.MapEntrie{Key: change.Key, Value: change.PreviousValue}
+		if change.HadPrevious {
+			_, err := mapSyncContext.HAProxyClient.UpdateMapEntrie(ctx, &entrie, change.MapName)
+			if err != nil {
+				log.Error().Err(err).Msgf("The '%s' entry of the '%s' map could not be restored.", change.Key, change.MapName)
+				continue
+			}
+			trail.add(audit.ActionUpdate, change.MapName, change.Key, change.Value, change.PreviousValue)
+			mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("updated", change.MapName)).Inc()
+		} else {
+			_, err := mapSyncContext.HAProxyClient.DeleteMapEntrie(ctx, &entrie, change.MapName)
+			if err != nil && !errors.Is(err, haproxy.ErrMapEntrieNotFound) {
+				log.Error().Err(err).Msgf("The '%s' entry of the '%s' map could not be deleted.", change.Key, change.MapName)
+				continue
+			}
+			trail.add(audit.ActionDelete, change.MapName, change.Key, change.Value, "")
+			mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("deleted", change.MapName)).Inc()
+		}
+		mapSyncContext.ManualEntries.Remove(change.MapName, change.Key)
+		affectedMaps[change.MapName] = true
+		log.Info().Msgf("The '%s' entry of the '%s' map expired.", change.Key, change.MapName)
+	}
+
+	for mapName := range affectedMaps {
+		updatePendingExpirations(mapSyncContext, mapName)
+	}
+}
+
+// mapNameParam returns the map name of the request path.
+func mapNameParam(c echo.Context) (string, error) {
+	mapName := c.Param("mapName")
+	if !mapNamePattern.MatchString(mapName) {
+		return "", fmt.Errorf("Invalid map name '%s'.", mapName)
+	}
+	return mapName, nil
+}
+
+// entrieParams returns the map name and the decoded key of the request path.
+// Echo routes on the raw path only when it differs from the decoded one, for
+// instance with an encoded slash, so the key is decoded only in that case.
+func entrieParams(c echo.Context) (string, string, error) {
+	mapName, err := mapNameParam(c)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := c.Param("key")
+	if c.Request().URL.RawPath != "" {
+		if key, err = url.PathUnescape(key); err != nil {
+			return "", "", errors.New("'key' param must be a non empty URL encoded string.")
+		}
+	}
+	if key == "" {
+		return "", "", errors.New("'key' param must be a non empty URL encoded string.")
+	}
+	return mapName, key, nil
+}
+
+// bindMapEntrie reads the request body and returns the expiry of its TTL or
+// expiry date, zero when there is none.
+func bindMapEntrie(c echo.Context) (MapEntrieRequestBody, time.Time, error) {
+	requestBody := MapEntrieRequestBody{}
+	if err := c.Bind(&requestBody); err != nil {
+		return requestBody, time.Time{}, errors.New("Error reading JSON request body.")
+	}
+
+	switch {
+	case requestBody.TTL != "" && requestBody.ExpiresAt != nil:
+		return requestBody, time.Time{}, errors.New("'ttl' and 'expires_at' cannot be both set.")
+	case requestBody.ExpiresAt != nil:
+		if !requestBody.ExpiresAt.After(time.Now()) {
+			return requestBody, time.Time{}, errors.New("'expires_at' must be in the future.")
+		}
+		return requestBody, requestBody.ExpiresAt.UTC(), nil
+	case requestBody.TTL != "":
+		ttl, err := time.ParseDuration(requestBody.TTL)
+		if err != nil || ttl <= 0 {
+			return requestBody, time.Time{}, fmt.Errorf("Invalid TTL '%s'.", requestBody.TTL)
+		}
+		return requestBody, time.Now().Add(ttl).UTC(), nil
+	}
+	return requestBody, time.Time{}, nil
+}
+
+func recordManualChange(mapSyncContext *client.MapSyncProxyAPI, trail *auditTrail, change overrides.Change) {
+	change.Caller = trail.caller
+	mapSyncContext.ManualEntries.Record(change)
+	// The entry no longer holds the synchronized value, whose expiry must not
+	// remove it.
+	mapSyncContext.Expirations.Remove(change.MapName, change.Key)
+
+	trail.source = manualSource
+	switch {
+	case change.Deleted:
+		trail.add(audit.ActionDelete, change.MapName, change.Key, change.PreviousValue, "")
+	case change.HadPrevious:
+		trail.add(audit.ActionUpdate, change.MapName, change.Key, change.PreviousValue, change.Value)
+	default:
+		trail.add(audit.ActionCreate, change.MapName, change.Key, "", change.Value)
+	}
+	trail.flush(mapSyncContext)
+
+	updatePendingExpirations(mapSyncContext, change.MapName)
+}
@@ -0,0 +1,230 @@
+package handlers_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/api/handlers"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy/haproxytest"
+	"github.com/matthisholleville/mapsyncproxy/pkg/history"
+)
+
+func TestMapEntrieLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits")
+
+	expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/entries", `{"key":"/api/v1", "value":"10"}`), http.StatusCreated)
+	expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/entries", `{"key":"/api/v1", "value":"20"}`), http.StatusConflict)
+
+	rec := s.do(http.MethodGet, "/v1/map/rate-limits/entries/%2Fapi%2Fv1", "")
+	expectStatus(t, rec, http.StatusOK)
+	entrie := haproxy.MapEntrie{}
+	decode(t, rec, &entrie)
+	if entrie.Key != "/api/v1" || entrie.Value != "10" {
+		t.Errorf("entry = %+v, want /api/v1 = 10", entrie)
+	}
+
+	expectStatus(t, s.do(http.MethodPut, "/v1/map/rate-limits/entries/%2Fapi%2Fv1", `{"value":"30"}`), http.StatusOK)
+	if got, expected := s.liveEntries(t, "rate-limits"), haproxytest.Entries("/api/v1", "30"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("live entries = %v, want %v", got, expected)
+	}
+
+	expectStatus(t, s.do(http.MethodDelete, "/v1/map/rate-limits/entries/%2Fapi%2Fv1", ""), http.StatusNoContent)
+	if got := s.liveEntries(t, "rate-limits"); len(got) != 0 {
+		t.Errorf("live entries = %v, want none", got)
+	}
+	if got := s.api.ManualEntries.List("rate-limits"); len(got) != 1 || !got[0].Deleted {
+		t.Errorf("manual changes = %+v, want the deletion of /api/v1", got)
+	}
+}
+
+func TestMapEntrieKeyDecoding(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits", haproxytest.Entries("a%b", "1", "a b", "2")...)
+
+	for target, value := range map[string]string{
+		"/v1/map/rate-limits/entries/a%25b": "1",
+		"/v1/map/rate-limits/entries/a%20b": "2",
+	} {
+		rec := s.do(http.MethodGet, target, "")
+		expectStatus(t, rec, http.StatusOK)
+		entrie := haproxy.MapEntrie{}
+		decode(t, rec, &entrie)
+		if entrie.Value != value {
+			t.Errorf("GET %s = %+v, want the value %s", target, entrie, value)
+		}
+	}
+}
+
+func TestMapEntrieErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		body    string
+		prepare func(s *testServer)
+		code    int
+	}{
+		{name: "get missing entry", method: http.MethodGet, target: "/v1/map/rate-limits/entries/missing", code: http.StatusNotFound},
+		{name: "update missing entry", method: http.MethodPut, target: "/v1/map/rate-limits/entries/missing", body: `{"value":"1"}`, code: http.StatusNotFound},
+		{name: "delete missing entry", method: http.MethodDelete, target: "/v1/map/rate-limits/entries/missing", code: http.StatusNotFound},
+		{name: "create without key", method: http.MethodPost, target: "/v1/map/rate-limits/entries", body: `{"value":"1"}`, code: http.StatusBadRequest},
+		{name: "create with invalid ttl", method: http.MethodPost, target: "/v1/map/rate-limits/entries", body: `{"key":"/new", "value":"1", "ttl":"-1m"}`, code: http.StatusBadRequest},
+		{name: "get with invalid map name", method: http.MethodGet, target: "/v1/map/rate%26limits/entries/%2Fapi", code: http.StatusBadRequest},
+		{name: "create with invalid map name", method: http.MethodPost, target: "/v1/map/rate%23limits/entries", body: `{"key":"/new", "value":"1"}`, code: http.StatusBadRequest},
+		{name: "update with invalid map name", method: http.MethodPut, target: "/v1/map/rate%3Flimits/entries/%2Fapi", body: `{"value":"1"}`, code: http.StatusBadRequest},
+		{name: "delete with invalid map name", method: http.MethodDelete, target: "/v1/map/rate%20limits/entries/%2Fapi", code: http.StatusBadRequest},
+		{name: "update with another key", method: http.MethodPut, target: "/v1/map/rate-limits/entries/%2Fapi", body: `{"key":"/other", "value":"1"}`, code: http.StatusBadRequest},
+		{
+			name:    "invalid entry",
+			method:  http.MethodPost,
+			target:  "/v1/map/rate-limits/entries",
+			body:    `{"key":"/new", "value":"1"}`,
+			prepare: func(s *testServer) { s.backend.FailOn("CreateMapEntrie", haproxy.ErrInvalidMapEntrie) },
+			code:    http.StatusBadRequest,
+		},
+		{
+			name:    "backend failure",
+			method:  http.MethodGet,
+			target:  "/v1/map/rate-limits/entries/%2Fapi",
+			prepare: func(s *testServer) { s.backend.FailOn("GetMapEntrie", errors.New("connection refused")) },
+			code:    http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10")...)
+			if test.prepare != nil {
+				test.prepare(s)
+			}
+
+			expectStatus(t, s.do(test.method, test.target, test.body), test.code)
+			if got, expected := s.liveEntries(t, "rate-limits"), haproxytest.Entries("/api", "10"); !reflect.DeepEqual(got, expected) {
+				t.Errorf("live entries = %v, want the map unchanged", got)
+			}
+		})
+	}
+}
+
+func TestExpireEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		change   func(t *testing.T, s *testServer)
+		expected []haproxy.MapEntrie
+	}{
+		{
+			name:     "synchronized value",
+			expected: haproxytest.Entries("/api", "10"),
+		},
+		{
+			name: "updated through the entries API",
+			change: func(t *testing.T, s *testServer) {
+				expectStatus(t, s.do(http.MethodPut, "/v1/map/rate-limits/entries/%2Fban", `{"value":"2"}`), http.StatusOK)
+			},
+			expected: haproxytest.Entries("/api", "10", "/ban", "2"),
+		},
+		{
+			name: "updated and preserved by a synchronization",
+			change: func(t *testing.T, s *testServer) {
+				expectStatus(t, s.do(http.MethodPut, "/v1/map/rate-limits/entries/%2Fban", `{"value":"2"}`), http.StatusOK)
+				expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", `, "preserve_manual_entries":true`)), http.StatusOK)
+			},
+			expected: haproxytest.Entries("/api", "10", "/ban", "2"),
+		},
+		{
+			name: "updated outside of mapSyncProxy",
+			change: func(t *testing.T, s *testServer) {
+				if _, err := s.backend.UpdateMapEntrie(context.Background(), &haproxy.MapEntrie{Key: "/ban", Value: "3"}, "rate-limits"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			expected: haproxytest.Entries("/api", "10", "/ban", "3"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expiresAt := time.Now().Add(100 * time.Millisecond)
+			s := newTestServer(t)
+			s.backend.LoadMap("rate-limits")
+			s.putSource(t, "rate-limits.json", []haproxy.MapEntrie{{Key: "/api", Value: "10"}, {Key: "/ban", Value: "1", ExpiresAt: &expiresAt}})
+			expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", "")), http.StatusOK)
+			if test.change != nil {
+				test.change(t, s)
+			}
+
+			time.Sleep(time.Until(expiresAt))
+			handlers.ExpireEntries(context.Background(), s.api)
+
+			if got := s.liveEntries(t, "rate-limits"); !reflect.DeepEqual(got, test.expected) {
+				t.Errorf("live entries = %v, want %v", got, test.expected)
+			}
+			if pending := s.api.Expirations.Pending("rate-limits"); pending != 0 {
+				t.Errorf("%d expirations still pending", pending)
+			}
+		})
+	}
+}
+
+func TestExpireEntriesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	persist := func(s *testServer) {
+		store := history.NewLocalStore(dir, history.Retention{})
+		if err := s.api.ManualEntries.Persist(store); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.api.Expirations.Persist(store); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := newTestServer(t)
+	persist(s)
+	s.backend.LoadMap("rate-limits")
+	expiresAt := time.Now().Add(100 * time.Millisecond)
+	s.putSource(t, "rate-limits.json", []haproxy.MapEntrie{{Key: "/api", Value: "10"}, {Key: "/synced", Value: "1", ExpiresAt: &expiresAt}})
+	expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", "")), http.StatusOK)
+	expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/entries", `{"key":"/ban", "value":"0", "ttl":"100ms"}`), http.StatusCreated)
+
+	restarted := newTestServer(t)
+	restarted.api.HAProxyClient = s.backend
+	restarted.backend = s.backend
+	persist(restarted)
+
+	time.Sleep(time.Until(expiresAt.Add(50 * time.Millisecond)))
+	handlers.ExpireEntries(context.Background(), restarted.api)
+
+	if got, expected := restarted.liveEntries(t, "rate-limits"), haproxytest.Entries("/api", "10"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("live entries = %v, want %v", got, expected)
+	}
+
+	// Nothing is left to expire after another restart.
+	again := newTestServer(t)
+	persist(again)
+	if pending := again.api.Expirations.Pending("rate-limits"); pending != 0 {
+		t.Errorf("%d expirations still saved", pending)
+	}
+	if changes := again.api.ManualEntries.List("rate-limits"); len(changes) != 0 {
+		t.Errorf("manual changes still saved: %+v", changes)
+	}
+}
+
+func TestSynchronizeSchedulesPartiallyAppliedExpirations(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10")...)
+	s.backend.FailOn("UpdateMapEntrie", errors.New("connection refused"))
+	expiresAt := time.Now().Add(time.Hour)
+	s.putSource(t, "rate-limits.json", []haproxy.MapEntrie{{Key: "/api", Value: "20"}, {Key: "/ban", Value: "0", ExpiresAt: &expiresAt}})
+
+	expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", "")), http.StatusInternalServerError)
+	if pending := s.api.Expirations.Pending("rate-limits"); pending != 1 {
+		t.Errorf("%d expirations pending, want the created /ban entry", pending)
+	}
+}
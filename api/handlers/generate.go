@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 	"github.com/matthisholleville/mapsyncproxy/api/client"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
 	"github.com/rs/zerolog/log"
 )
 
@@ -32,15 +34,63 @@ func GenerateJsonFromMap(c echo.Context) (err error) {
 
 	mapSyncContext.ServerMetrics.GenerateJsonFromMapTotalCount.With(setMetricsStatusLabels("processed", mapName)).Inc()
 
-	// Get HAProxy entries from map
-	haproxyEntries, err := mapSyncContext.HAProxyClient.GetMapEntries(mapName)
+	// Stream HAProxy entries from map
+	stream := newJSONArrayStream(c)
+	err = mapSyncContext.HAProxyClient.StreamMapEntries(c.Request().Context(), mapName, stream.Write)
 	if err != nil {
 		log.Debug().Err(err).Msg("The entries from the HAProxy Map file could not be retrieved or interpreted.")
 		mapSyncContext.ServerMetrics.GenerateJsonFromMapTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
+		if stream.Started() {
+			// The status is already sent, the truncated body is the only signal left.
+			return nil
+		}
 		return c.JSON(http.StatusInternalServerError, jsonResponse("The entries from the HAProxy Map file could not be retrieved or interpreted."))
 	}
 
 	mapSyncContext.ServerMetrics.GenerateJsonFromMapTotalCount.With(setMetricsStatusLabels("success", mapName)).Inc()
-	return c.JSON(http.StatusOK, haproxyEntries)
+	return stream.Close()
 
 }
+
+// jsonArrayStream writes a JSON array to the response element by element.
+// The response header is sent with the first element, so that an error
+// before it can still be answered with a regular error response.
+type jsonArrayStream struct {
+	c       echo.Context
+	encoder *json.Encoder
+}
+
+func newJSONArrayStream(c echo.Context) *jsonArrayStream {
+	return &jsonArrayStream{c: c, encoder: json.NewEncoder(c.Response())}
+}
+
+func (s *jsonArrayStream) Write(entrie haproxy.MapEntrie) error {
+	separator := ","
+	if !s.Started() {
+		s.start()
+		separator = "["
+	}
+	if _, err := s.c.Response().Write([]byte(separator)); err != nil {
+		return err
+	}
+	return s.encoder.Encode(entrie)
+}
+
+func (s *jsonArrayStream) Started() bool {
+	return s.c.Response().Committed
+}
+
+func (s *jsonArrayStream) Close() error {
+	closing := "]"
+	if !s.Started() {
+		s.start()
+		closing = "[]"
+	}
+	_, err := s.c.Response().Write([]byte(closing))
+	return err
+}
+
+func (s *jsonArrayStream) start() {
+	s.c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	s.c.Response().WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,51 @@
+package handlers_test
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy/haproxytest"
+)
+
+func TestGenerateJsonFromMap(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10", "/login", "5")...)
+
+	rec := s.do(http.MethodGet, "/v1/map/rate-limits/generate", "")
+	expectStatus(t, rec, http.StatusOK)
+
+	entries := []haproxy.MapEntrie{}
+	decode(t, rec, &entries)
+	for i := range entries {
+		if entries[i].Id == "" {
+			t.Errorf("entry %s has no id", entries[i].Key)
+		}
+		entries[i].Id = ""
+	}
+	if expected := haproxytest.Entries("/api", "10", "/login", "5"); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("entries = %v, want %v", entries, expected)
+	}
+}
+
+func TestGenerateJsonFromEmptyMap(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits")
+
+	rec := s.do(http.MethodGet, "/v1/map/rate-limits/generate", "")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "[]" {
+		t.Errorf("body = %q, want an empty array", rec.Body.String())
+	}
+}
+
+func TestGenerateJsonFromMapErrors(t *testing.T) {
+	s := newTestServer(t)
+	expectStatus(t, s.do(http.MethodGet, "/v1/map/missing/generate", ""), http.StatusInternalServerError)
+
+	s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10")...)
+	s.backend.FailOn("StreamMapEntries", errors.New("connection refused"))
+	expectStatus(t, s.do(http.MethodGet, "/v1/map/rate-limits/generate", ""), http.StatusInternalServerError)
+}
@@ -0,0 +1,104 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/labstack/echo/v4"
+	"github.com/matthisholleville/mapsyncproxy/api/client"
+	v1 "github.com/matthisholleville/mapsyncproxy/api/v1"
+	"github.com/matthisholleville/mapsyncproxy/pkg/audit"
+	"github.com/matthisholleville/mapsyncproxy/pkg/expiry"
+	"github.com/matthisholleville/mapsyncproxy/pkg/gcs/gcstest"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy/haproxytest"
+	"github.com/matthisholleville/mapsyncproxy/pkg/metrics"
+	"github.com/matthisholleville/mapsyncproxy/pkg/overrides"
+	"github.com/matthisholleville/mapsyncproxy/pkg/status"
+)
+
+const testBucket = "test-bucket"
+
+// testServer serves the v1 API on top of an in-memory HAProxy backend and
+// bucket.
+type testServer struct {
+	api     *client.MapSyncProxyAPI
+	backend *haproxytest.FakeBackend
+	bucket  *gcstest.FakeStorage
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	backend := haproxytest.NewFakeBackend()
+	bucket := gcstest.NewFakeStorage()
+	s := &client.MapSyncProxyAPI{
+		Echo:             echo.New(),
+		HAProxyClient:    backend,
+		GCSClientWrapper: bucket,
+		ServerMetrics:    metrics.New(),
+		AuditLogger:      audit.New(100),
+		ManualEntries:    overrides.NewRegistry(),
+		Expirations:      expiry.NewSchedule(),
+		SyncStatuses:     status.NewTracker(),
+	}
+	v1.API(s)
+
+	return &testServer{api: s, backend: backend, bucket: bucket}
+}
+
+func (s *testServer) do(method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	s.api.Echo.ServeHTTP(rec, req)
+	return rec
+}
+
+// putSource stores a JSON source file holding the given entries.
+func (s *testServer) putSource(t *testing.T, name string, entries []haproxy.MapEntrie) storage.ObjectAttrs {
+	t.Helper()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.bucket.PutObject(testBucket, storage.ObjectAttrs{Name: name, ContentType: "application/json"}, data)
+}
+
+// liveEntries returns the entries of a map without their id.
+func (s *testServer) liveEntries(t *testing.T, mapName string) []haproxy.MapEntrie {
+	t.Helper()
+
+	live, err := s.backend.GetMapEntries(context.Background(), mapName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := []haproxy.MapEntrie{}
+	for _, entrie := range *live {
+		result = append(result, haproxy.MapEntrie{Key: entrie.Key, Value: entrie.Value})
+	}
+	return result
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, code int) {
+	t.Helper()
+
+	if rec.Code != code {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, code, rec.Body.String())
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/matthisholleville/mapsyncproxy/api/client"
+	"github.com/matthisholleville/mapsyncproxy/pkg/history"
+	"github.com/rs/zerolog/log"
+)
+
+// GetMapHistory godoc
+//
+//	@Tags			History
+//	@Summary		List the recorded revisions of a map.
+//	@Description	List the recorded revisions of a map, without their snapshot.
+//	@Accept			json
+//	@Produce		json
+//	@Param		map_name	path	string				true	"Map name"//
+//
+// @Success		200	{array}	history.Revision
+// @Failure		404		"Map history is not enabled"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/map/{map_name}/history [get]
+func GetMapHistory(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+
+	mapName := c.Param("mapName")
+	if mapName == "" {
+		log.Debug().Err(err).Msg("'map_name' param cannot be empty.")
+		return c.JSON(http.StatusInternalServerError, jsonResponse("'map_name' param cannot be empty."))
+	}
+
+	if mapSyncContext.HistoryStore == nil {
+		return c.JSON(http.StatusNotFound, jsonResponse("Map history is not enabled."))
+	}
+
+	revisions, err := mapSyncContext.HistoryStore.List(mapName)
+	if err != nil {
+		log.Debug().Err(err).Msg("The map history could not be retrieved.")
+		return c.JSON(http.StatusInternalServerError, jsonResponse("The map history could not be retrieved."))
+	}
+
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// GetMapRevision godoc
+//
+//	@Tags			History
+//	@Summary		Get a recorded revision of a map.
+//	@Description	Get a recorded revision of a map with its snapshot and applied diff.
+//	@Accept			json
+//	@Produce		json
+//	@Param		map_name	path	string				true	"Map name"//
+//	@Param		rev			path	int					true	"Revision"//
+//
+// @Success		200	{object}	history.Revision
+// @Failure		400		"Invalid revision"
+// @Failure		404		"Revision not found"
+// @Failure		409		"Revision snapshot missing"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/map/{map_name}/history/{rev} [get]
+func GetMapRevision(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+
+	revision, status, err := getRevision(mapSyncContext, c.Param("mapName"), c.Param("rev"))
+	if err != nil {
+		return c.JSON(status, jsonResponse(err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, revision)
+}
+
+// RollbackMap godoc
+//
+//	@Tags			History
+//	@Summary		Restore a recorded revision of a map.
+//	@Description	Restore the map as it was after a recorded revision, or before it with state=before.
+//	@Accept			json
+//	@Produce		json
+//	@Param		map_name	path	string				true	"Map name"//
+//	@Param		rev			path	int					true	"Revision"//
+//	@Param		state		query	string				false	"after (default) or before"//
+//	@Param		preserve_manual_entries	query	bool	false	"Keep the entries changed through the entries API since the previous synchronization"//
+//
+// @Success		200	{object}	SynchronizeReport
+// @Failure		400		"Invalid revision"
+// @Failure		404		"Revision not found"
+// @Failure		409		"Revision snapshot missing"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/map/{map_name}/rollback/{rev} [post]
+func RollbackMap(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+	mapName := c.Param("mapName")
+
+	state := c.QueryParam("state")
+	if state != "" && state != "after" && state != "before" {
+		return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Unknown state '%s'.", state)))
+	}
+
+	preserveManualEntries := false
+	if value := c.QueryParam("preserve_manual_entries"); value != "" {
+		if preserveManualEntries, err = strconv.ParseBool(value); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Invalid preserve_manual_entries '%s'.", value)))
+		}
+	}
+
+	revision, status, err := getRevision(mapSyncContext, mapName, c.Param("rev"))
+	if err != nil {
+		return c.JSON(status, jsonResponse(err.Error()))
+	}
+
+	desiredEntries := revision.Entries()
+	if state == "before" {
+		desiredEntries = revision.Snapshot
+	}
+	manualChanges := mapSyncContext.ManualEntries.List(mapName)
+	if preserveManualEntries {
+		desiredEntries = applyManualChanges(desiredEntries, manualChanges)
+	}
+
+	mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("processed", mapName)).Inc()
+	defer recordSyncStatus(c, mapSyncContext, mapName, history.OriginRollback, time.Now())
+
+	trail := newAuditTrail(c, mapSyncContext)
+	trail.source = fmt.Sprintf("%s:%d", history.OriginRollback, revision.Revision)
+
+	result, err := reconcileMap(c.Request().Context(), mapSyncContext, mapName, desiredEntries, false, trail)
+	canceled := &canceledError{}
+	if errors.As(err, &canceled) {
+		return canceledSync(c, mapSyncContext, mapName, canceled, result)
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("The HAProxy Map file could not be rolled back.")
+		mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
+		return c.JSON(http.StatusInternalServerError, jsonResponse(err.Error()))
+	}
+
+	if !preserveManualEntries {
+		mapSyncContext.ManualEntries.Clear(mapName)
+	}
+	updatePendingExpirations(mapSyncContext, mapName)
+
+	newRevision := recordRevision(mapSyncContext, &history.Revision{
+		MapName:    mapName,
+		Origin:     history.OriginRollback,
+		RollbackOf: revision.Revision,
+		Snapshot:   result.Snapshot,
+		Diff:       result.Diff,
+	})
+
+	log.Info().Msgf("Rollback to revision %d success. %d created - %d updated - %d deleted - %d duplicates", revision.Revision, len(result.Diff.Created), len(result.Diff.Updated), len(result.Diff.Deleted), len(result.Diff.Duplicates))
+	mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("success", mapName)).Inc()
+	return c.JSON(http.StatusOK, SynchronizeReport{
+		Status:        "rollback success.",
+		Created:       len(result.Diff.Created),
+		Updated:       len(result.Diff.Updated),
+		Deleted:       len(result.Diff.Deleted),
+		Unchanged:     result.Unchanged,
+		Duplicates:    result.Diff.Duplicates,
+		Revision:      newRevision,
+		ManualEntries: manualEntrieReports(manualChanges, preserveManualEntries),
+	})
+}
+
+// getRevision loads a revision from the path parameters and returns the HTTP
+// status to answer with when it cannot.
+func getRevision(mapSyncContext *client.MapSyncProxyAPI, mapName, rev string) (*history.Revision, int, error) {
+	if mapName == "" {
+		return nil, http.StatusInternalServerError, errors.New("'map_name' param cannot be empty.")
+	}
+
+	if mapSyncContext.HistoryStore == nil {
+		return nil, http.StatusNotFound, errors.New("Map history is not enabled.")
+	}
+
+	revisionNumber, err := strconv.Atoi(rev)
+	if err != nil || revisionNumber < 1 {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid revision '%s'.", rev)
+	}
+
+	revision, err := mapSyncContext.HistoryStore.Get(mapName, revisionNumber)
+	if errors.Is(err, history.ErrRevisionNotFound) {
+		return nil, http.StatusNotFound, fmt.Errorf("Revision %d of the '%s' map not found.", revisionNumber, mapName)
+	}
+	if errors.Is(err, history.ErrSnapshotNotFound) {
+		return nil, http.StatusConflict, fmt.Errorf("The snapshot of revision %d of the '%s' map is missing.", revisionNumber, mapName)
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("The map revision could not be retrieved.")
+		return nil, http.StatusInternalServerError, errors.New("The map revision could not be retrieved.")
+	}
+
+	return revision, http.StatusOK, nil
+}
@@ -0,0 +1,40 @@
+package handlers_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy/haproxytest"
+	"github.com/matthisholleville/mapsyncproxy/pkg/history"
+)
+
+func TestRollbackMissingSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t)
+	s.api.HistoryStore = history.NewLocalStore(dir, history.Retention{})
+	s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10", "/login", "5")...)
+	s.putSource(t, "rate-limits.json", haproxytest.Entries("/api", "20"))
+	expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", "")), http.StatusOK)
+
+	snapshots, err := filepath.Glob(filepath.Join(dir, "rate-limits", "*.snapshot.json"))
+	if err != nil || len(snapshots) != 1 {
+		t.Fatalf("snapshots = %v, %v, want one", snapshots, err)
+	}
+	if err := os.Remove(snapshots[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, request := range []struct{ method, target string }{
+		{http.MethodGet, "/v1/map/rate-limits/history/1"},
+		{http.MethodPost, "/v1/map/rate-limits/rollback/1"},
+		{http.MethodPost, "/v1/map/rate-limits/rollback/1?state=before"},
+	} {
+		expectStatus(t, s.do(request.method, request.target, ""), http.StatusConflict)
+	}
+	if got, expected := s.liveEntries(t, "rate-limits"), haproxytest.Entries("/api", "20"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("live entries = %v, want the map unchanged", got)
+	}
+}
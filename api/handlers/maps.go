@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/matthisholleville/mapsyncproxy/api/client"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/status"
+	"github.com/rs/zerolog/log"
+)
+
+var mapNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ListMaps godoc
+//
+//	@Tags			Map
+//	@Summary		List the maps loaded in HAProxy.
+//	@Description	List the maps loaded in HAProxy with their entry count and last synchronization status.
+//	@Accept			json
+//	@Produce		json
+//
+// @Success		200	{array}	MapInfo
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/maps [get]
+func ListMaps(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+
+	maps, err := mapSyncContext.HAProxyClient.ListMaps(c.Request().Context())
+	if err != nil {
+		log.Debug().Err(err).Msg("The HAProxy maps could not be listed.")
+		return c.JSON(http.StatusInternalServerError, jsonResponse("The HAProxy maps could not be listed."))
+	}
+
+	result := []MapInfo{}
+	for _, runtimeMap := range *maps {
+		info := MapInfo{
+			Name:    runtimeMap.Name(),
+			File:    runtimeMap.File,
+			Id:      runtimeMap.Id,
+			Entries: runtimeMap.EntryCount(),
+		}
+		if lastSync, exists := mapSyncContext.SyncStatuses.Get(info.Name); exists {
+			info.LastSync = &lastSync
+		}
+		result = append(result, info)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// CreateMap godoc
+//
+//	@Tags			Map
+//	@Summary		Create a map file.
+//	@Description	Create a map file in the Dataplane storage. HAProxy loads it once a configuration referencing it is reloaded.
+//	@Accept			json
+//	@Produce		json
+//	@Param		_	body	CreateMapRequestBody	true	"Map to create"
+//
+// @Success		201	{object}	haproxy.StorageMap
+// @Failure		400		"Invalid request body"
+// @Failure		409		"Map already exists"
+// @Failure		500		"Internal Server Error"
+// @Failure		501		"Not supported by the HAProxy backend"
+// @Router			/v1/maps [post]
+func CreateMap(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+	requestBody := CreateMapRequestBody{}
+
+	if err := c.Bind(&requestBody); err != nil {
+		return c.JSON(http.StatusBadRequest, jsonResponse("Error reading JSON request body."))
+	}
+
+	if !mapNamePattern.MatchString(requestBody.Name) {
+		return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Invalid map name '%s'.", requestBody.Name)))
+	}
+
+	if hasDuplicateKeys(requestBody.Entries) {
+		return c.JSON(http.StatusBadRequest, jsonResponse("The map entries contain duplicate keys."))
+	}
+
+	storage, ok := mapSyncContext.HAProxyClient.(haproxy.MapStorage)
+	if !ok {
+		return c.JSON(http.StatusNotImplemented, jsonResponse("The HAProxy backend cannot manage map files."))
+	}
+
+	storageMap, err := storage.CreateMap(c.Request().Context(), requestBody.Name, requestBody.Entries)
+	if errors.Is(err, haproxy.ErrInvalidMapEntrie) {
+		return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Invalid map entries: %s.", err)))
+	}
+	if errors.Is(err, haproxy.ErrMapAlreadyExists) {
+		return c.JSON(http.StatusConflict, jsonResponse(fmt.Sprintf("The '%s' map already exists.", requestBody.Name)))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("The '%s' map could not be created.", requestBody.Name)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' map could not be created.", requestBody.Name)))
+	}
+
+	log.Info().Msgf("The '%s' map was created with %d entrie(s).", requestBody.Name, len(requestBody.Entries))
+	return c.JSON(http.StatusCreated, storageMap)
+}
+
+// ClearMap godoc
+//
+//	@Tags			Map
+//	@Summary		Remove every entry of a map.
+//	@Description	Remove every entry of a runtime map.
+//	@Accept			json
+//	@Produce		json
+//	@Param		map_name	path	string				true	"Map name"//
+//
+// @Success		204
+// @Failure		400		"Invalid map name"
+// @Failure		404		"Map not found"
+// @Failure		500		"Internal Server Error"
+// @Router			/v1/map/{map_name}/clear [post]
+func ClearMap(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+	mapName := c.Param("mapName")
+
+	if !mapNamePattern.MatchString(mapName) {
+		return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Invalid map name '%s'.", mapName)))
+	}
+
+	err = mapSyncContext.HAProxyClient.ClearMap(c.Request().Context(), mapName)
+	if errors.Is(err, haproxy.ErrMapNotFound) {
+		return c.JSON(http.StatusNotFound, jsonResponse(fmt.Sprintf("The '%s' map does not exist.", mapName)))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("The '%s' map could not be cleared.", mapName)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' map could not be cleared.", mapName)))
+	}
+
+	forgetMapState(mapSyncContext, mapName)
+	log.Info().Msgf("The '%s' map was cleared.", mapName)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteMap godoc
+//
+//	@Tags			Map
+//	@Summary		Delete a map file.
+//	@Description	Delete a map file from the Dataplane storage. Maps referenced in the running configuration cannot be deleted.
+//	@Accept			json
+//	@Produce		json
+//	@Param		map_name	path	string				true	"Map name"//
+//
+// @Success		204
+// @Failure		400		"Invalid map name"
+// @Failure		404		"Map not found"
+// @Failure		409		"Map referenced in the running configuration"
+// @Failure		500		"Internal Server Error"
+// @Failure		501		"Not supported by the HAProxy backend"
+// @Router			/v1/map/{map_name} [delete]
+func DeleteMap(c echo.Context) (err error) {
+	mapSyncContext := c.Get("mapSyncContext").(*client.MapSyncProxyAPI)
+	mapName := c.Param("mapName")
+
+	if !mapNamePattern.MatchString(mapName) {
+		return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Invalid map name '%s'.", mapName)))
+	}
+
+	storage, ok := mapSyncContext.HAProxyClient.(haproxy.MapStorage)
+	if !ok {
+		return c.JSON(http.StatusNotImplemented, jsonResponse("The HAProxy backend cannot manage map files."))
+	}
+
+	referenced, err := storage.IsMapReferenced(c.Request().Context(), mapName)
+	if err != nil {
+		log.Debug().Err(err).Msg("The running configuration could not be retrieved.")
+		return c.JSON(http.StatusInternalServerError, jsonResponse("The running configuration could not be retrieved."))
+	}
+	if referenced {
+		return c.JSON(http.StatusConflict, jsonResponse(fmt.Sprintf("The '%s' map is referenced in the running configuration.", mapName)))
+	}
+
+	err = storage.DeleteMap(c.Request().Context(), mapName)
+	if errors.Is(err, haproxy.ErrMapNotFound) {
+		return c.JSON(http.StatusNotFound, jsonResponse(fmt.Sprintf("The '%s' map does not exist.", mapName)))
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("The '%s' map could not be deleted.", mapName)
+		return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' map could not be deleted.", mapName)))
+	}
+
+	forgetMapState(mapSyncContext, mapName)
+	log.Info().Msgf("The '%s' map was deleted.", mapName)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// forgetMapState drops the manual changes and the expirations of a map whose
+// entries were all removed.
+func forgetMapState(mapSyncContext *client.MapSyncProxyAPI, mapName string) {
+	mapSyncContext.ManualEntries.Clear(mapName)
+	mapSyncContext.Expirations.Replace(mapName, nil)
+	updatePendingExpirations(mapSyncContext, mapName)
+}
+
+// recordSyncStatus records the outcome and the duration of a synchronization
+// from the status of the response. It is meant to be deferred once the
+// synchronization starts.
+func recordSyncStatus(c echo.Context, mapSyncContext *client.MapSyncProxyAPI, mapName, origin string, startedAt time.Time) {
+	syncStatus := status.SyncStatus{
+		Status:     status.StatusSuccess,
+		HTTPStatus: c.Response().Status,
+		Origin:     origin,
+		StartedAt:  startedAt,
+		FinishedAt: time.Now(),
+	}
+	metricStatus := "success"
+	if syncStatus.HTTPStatus >= http.StatusBadRequest {
+		syncStatus.Status = status.StatusError
+		metricStatus = "error"
+	}
+	if syncStatus.HTTPStatus == http.StatusGatewayTimeout {
+		metricStatus = "canceled"
+	}
+	mapSyncContext.SyncStatuses.Set(mapName, syncStatus)
+	mapSyncContext.ServerMetrics.SynchronizationDuration.With(setMetricsStatusLabels(metricStatus, mapName)).Observe(syncStatus.FinishedAt.Sub(startedAt).Seconds())
+}
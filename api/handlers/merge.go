@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+)
+
+const (
+	mergeStrategyReject      = "reject"
+	mergeStrategyFirstWins   = "first-wins"
+	mergeStrategyLastWins    = "last-wins"
+	mergeStrategyLastUpdated = "last-updated"
+	mergeStrategyPriority    = "priority"
+)
+
+type sourceFile struct {
+	Name       string
+	Updated    time.Time
+	Generation int64
+	SHA256     string
+	Entries    []haproxy.MapEntrie
+}
+
+// keyConflictError is returned when the merge strategy cannot settle keys
+// defined in several source files.
+type keyConflictError struct {
+	Conflicts []KeyConflict
+}
+
+func (e *keyConflictError) Error() string {
+	details := []string{}
+	for _, conflict := range e.Conflicts {
+		details = append(details, fmt.Sprintf("'%s' (%s)", conflict.Key, strings.Join(conflict.Files, ", ")))
+	}
+	return fmt.Sprintf("conflicting keys: %s", strings.Join(details, "; "))
+}
+
+func isValidMergeStrategy(strategy string) bool {
+	switch strategy {
+	case "", mergeStrategyReject, mergeStrategyFirstWins, mergeStrategyLastWins, mergeStrategyLastUpdated, mergeStrategyPriority:
+		return true
+	}
+	return false
+}
+
+// mergeSourceFiles merges the entries of several source files into a single
+// list. Files are walked from the highest to the lowest precedence and the
+// first file defining a key owns it. Conflicts that the strategy cannot settle
+// are returned as a *keyConflictError naming the keys and the files involved. The name of
+// the file owning each key is returned alongside the entries.
+func mergeSourceFiles(files []sourceFile, strategy string, priorities []string) ([]haproxy.MapEntrie, map[string]string, error) {
+	ordered := orderSourceFiles(files, strategy, priorities)
+
+	result := []haproxy.MapEntrie{}
+	owners := make(map[string]string)
+	definedIn := make(map[string][]string)
+	conflicted := make(map[string]bool)
+	conflicts := []string{}
+
+	for _, file := range ordered {
+		for _, entrie := range file.Entries {
+			definedIn[entrie.Key] = append(definedIn[entrie.Key], file.Name)
+			owner, exists := owners[entrie.Key]
+			if !exists {
+				owners[entrie.Key] = file.Name
+				result = append(result, entrie)
+				continue
+			}
+			if !conflicted[entrie.Key] && !settlesConflict(strategy, priorities, owner, file.Name) {
+				conflicted[entrie.Key] = true
+				conflicts = append(conflicts, entrie.Key)
+			}
+		}
+	}
+
+	if len(conflicts) > 0 {
+		err := &keyConflictError{}
+		for _, key := range conflicts {
+			err.Conflicts = append(err.Conflicts, KeyConflict{Key: key, Files: definedIn[key]})
+		}
+		return nil, nil, err
+	}
+
+	return result, owners, nil
+}
+
+// settlesConflict reports whether the strategy lets the owner file win over
+// another file defining the same key.
+func settlesConflict(strategy string, priorities []string, owner, other string) bool {
+	switch strategy {
+	case "", mergeStrategyReject:
+		return false
+	case mergeStrategyPriority:
+		return priorityRank(owner, priorities) != priorityRank(other, priorities)
+	}
+	return true
+}
+
+// orderSourceFiles returns the files sorted from the highest to the lowest
+// precedence for the given strategy. Files are expected in listing order.
+func orderSourceFiles(files []sourceFile, strategy string, priorities []string) []sourceFile {
+	ordered := make([]sourceFile, len(files))
+	copy(ordered, files)
+
+	switch strategy {
+	case mergeStrategyLastWins:
+		for i, j := 0, len(ordered)-1; i < j; i, j = i+1, j-1 {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		}
+	case mergeStrategyLastUpdated:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].Updated.After(ordered[j].Updated)
+		})
+	case mergeStrategyPriority:
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return priorityRank(ordered[i].Name, priorities) < priorityRank(ordered[j].Name, priorities)
+		})
+	}
+
+	return ordered
+}
+
+// duplicateKeyConflicts returns the keys defined more than once within the
+// same source file.
+func duplicateKeyConflicts(files []sourceFile) []KeyConflict {
+	conflicts := []KeyConflict{}
+	for _, file := range files {
+		for _, key := range duplicateKeys(file.Entries) {
+			conflicts = append(conflicts, KeyConflict{Key: key, Files: []string{file.Name}})
+		}
+	}
+	return conflicts
+}
+
+// priorityRank returns the index of the first prefix matching the file name,
+// or len(priorities) when none does.
+func priorityRank(fileName string, priorities []string) int {
+	for i, prefix := range priorities {
+		if strings.HasPrefix(fileName, prefix) {
+			return i
+		}
+	}
+	return len(priorities)
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/api/client"
+	"github.com/matthisholleville/mapsyncproxy/pkg/audit"
+	"github.com/matthisholleville/mapsyncproxy/pkg/diff"
+	"github.com/matthisholleville/mapsyncproxy/pkg/expiry"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/history"
+	"github.com/matthisholleville/mapsyncproxy/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// entrieError reports the map entry on which the reconciliation stopped.
+type entrieError struct {
+	Operation string
+	Key       string
+	Err       error
+}
+
+// Error includes the Dataplane API message, when the Dataplane API gave one.
+func (e *entrieError) Error() string {
+	apiError := &haproxy.APIError{}
+	if errors.As(e.Err, &apiError) && apiError.Message != "" {
+		return fmt.Sprintf("The '%s' entry could not be %s: %s", e.Key, e.Operation, apiError.Message)
+	}
+	return fmt.Sprintf("The '%s' entry could not be %s.", e.Key, e.Operation)
+}
+
+func (e *entrieError) Unwrap() error {
+	return e.Err
+}
+
+// errLiveMapUnavailable is returned when the live HAProxy map cannot be read.
+var errLiveMapUnavailable = errors.New("The entries from the HAProxy Map file could not be retrieved or interpreted.")
+
+// The phases of a synchronization, each bounded by its own deadline.
+const (
+	phaseDownload = "download"
+	phaseDiff     = "diff"
+	phaseApply    = "apply"
+)
+
+// phaseFetch is the part of the diff phase spent reading the live map. It is
+// only measured, and shares the deadline of the diff phase.
+const phaseFetch = "fetch"
+
+// canceledError reports the phase during which a synchronization was stopped
+// by the caller disconnecting or by the phase deadline.
+type canceledError struct {
+	Phase string
+	Err   error
+}
+
+func (e *canceledError) Error() string {
+	return fmt.Sprintf("The synchronization was canceled during the %s phase.", e.Phase)
+}
+
+func (e *canceledError) Unwrap() error {
+	return e.Err
+}
+
+// reconciliation is the outcome of reconcileMap.
+type reconciliation struct {
+	// Diff holds the applied changes, up to the failing one.
+	Diff      history.Diff
+	Unchanged int
+	// Snapshot holds the live entries read before applying the changes. It is
+	// only kept when the map history is enabled.
+	Snapshot []haproxy.MapEntrie
+}
+
+// reconcileMap creates, deletes and updates the HAProxy map entries so that
+// the map matches the desired entries. Every applied change is recorded in the
+// audit log, even when a later one fails. With atomic, the entries are instead
+// replaced at once by a backend implementing haproxy.AtomicReplacer. Expired
+// desired entries are treated as absent, and the expiry of the others is
+// scheduled once they are applied.
+func reconcileMap(ctx context.Context, mapSyncContext *client.MapSyncProxyAPI, mapName string, desired []haproxy.MapEntrie, atomic bool, trail *auditTrail) (*reconciliation, error) {
+	desired = expiry.Active(desired, time.Now())
+	mapSyncContext.ServerMetrics.DesiredMapEntriesCount.With(prometheus.Labels{"map_name": mapName}).Set(float64(len(desired)))
+
+	diffCtx, cancelDiff := withPhaseTimeout(ctx, mapSyncContext.SyncTimeouts.Diff)
+	defer cancelDiff()
+	changeset, snapshot, err := diffLiveMap(diffCtx, mapSyncContext, mapName, desired, mapSyncContext.HistoryStore != nil)
+	if isCanceled(err) {
+		return &reconciliation{}, &canceledError{Phase: phaseDiff, Err: err}
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("The entries from the HAProxy Map file could not be retrieved or interpreted.")
+		return &reconciliation{}, errLiveMapUnavailable
+	}
+
+	result := &reconciliation{
+		Unchanged: changeset.Count(diff.Unchanged),
+		Snapshot:  snapshot,
+	}
+	applyCtx, cancelApply := withPhaseTimeout(ctx, mapSyncContext.SyncTimeouts.Apply)
+	defer cancelApply()
+	applyStartedAt := time.Now()
+	if atomic {
+		result.Diff, err = replaceEntries(applyCtx, mapSyncContext, mapName, desired, changeset, trail)
+	} else {
+		result.Diff, err = applyChanges(applyCtx, mapSyncContext, mapName, changeset, trail)
+	}
+	observePhase(mapSyncContext, mapName, phaseApply, time.Since(applyStartedAt))
+	if err != nil {
+		// The entries applied before the failure expire all the same.
+		mapSyncContext.Expirations.Add(mapName, result.Diff.Created)
+		mapSyncContext.Expirations.Add(mapName, result.Diff.Updated)
+	}
+	if isCanceled(err) {
+		return result, &canceledError{Phase: phaseApply, Err: err}
+	}
+	if err != nil {
+		return result, err
+	}
+
+	mapSyncContext.ServerMetrics.LiveMapEntriesCount.With(prometheus.Labels{"map_name": mapName}).Set(float64(len(desired)))
+	mapSyncContext.Expirations.Replace(mapName, desired)
+	return result, nil
+}
+
+// diffLiveMap streams the live map into a differ, so that memory is bounded
+// by the desired entries and the changes rather than by the size of the live
+// map, unless a snapshot is kept for the history. The time spent reading the
+// live map and the time spent comparing the entries are measured apart, as
+// they interleave.
+func diffLiveMap(ctx context.Context, mapSyncContext *client.MapSyncProxyAPI, mapName string, desired []haproxy.MapEntrie, keepSnapshot bool) (_ *diff.Changeset, _ []haproxy.MapEntrie, err error) {
+	ctx, span := tracing.Start(ctx, "map.diff", attribute.String("map.name", mapName), attribute.Int("map.desired_entries", len(desired)))
+	defer func() { tracing.End(span, err) }()
+
+	diffStartedAt := time.Now()
+	differ := diff.New(desired)
+	diffDuration := time.Since(diffStartedAt)
+	var snapshot []haproxy.MapEntrie
+	var observing time.Duration
+	live := 0
+
+	fetchStartedAt := time.Now()
+	err = mapSyncContext.HAProxyClient.StreamMapEntries(ctx, mapName, func(entrie haproxy.MapEntrie) error {
+		if keepSnapshot {
+			snapshot = append(snapshot, entrie)
+		}
+		live++
+		observedAt := time.Now()
+		differ.Observe(entrie)
+		observing += time.Since(observedAt)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	fetchDuration := time.Since(fetchStartedAt) - observing
+
+	changesStartedAt := time.Now()
+	changeset := differ.Changeset()
+	diffDuration += observing + time.Since(changesStartedAt)
+
+	observePhase(mapSyncContext, mapName, phaseFetch, fetchDuration)
+	observePhase(mapSyncContext, mapName, phaseDiff, diffDuration)
+	drift := changeset.Count(diff.Create) + changeset.Count(diff.Update) + changeset.Count(diff.Delete) + changeset.Count(diff.Duplicate)
+	mapSyncContext.ServerMetrics.LiveMapEntriesCount.With(prometheus.Labels{"map_name": mapName}).Set(float64(live))
+	mapSyncContext.ServerMetrics.MapDriftCount.With(prometheus.Labels{"map_name": mapName}).Set(float64(drift))
+	span.SetAttributes(attribute.Int("map.live_entries", live), attribute.Int("map.drift_entries", drift))
+	return changeset, snapshot, nil
+}
+
+// observePhase records the duration of a synchronization phase.
+func observePhase(mapSyncContext *client.MapSyncProxyAPI, mapName, phase string, duration time.Duration) {
+	mapSyncContext.ServerMetrics.SynchronizationPhaseDuration.With(prometheus.Labels{"phase": phase, "map_name": mapName}).Observe(duration.Seconds())
+}
+
+// applyChanges applies the creations, then the deletions, then the removal of
+// the duplicated live entries, then the updates.
+func applyChanges(ctx context.Context, mapSyncContext *client.MapSyncProxyAPI, mapName string, changeset *diff.Changeset, trail *auditTrail) (_ history.Diff, err error) {
+	ctx, span := tracing.Start(ctx, "map.apply",
+		attribute.String("map.name", mapName),
+		attribute.Int("map.created", changeset.Count(diff.Create)),
+		attribute.Int("map.deleted", changeset.Count(diff.Delete)),
+		attribute.Int("map.duplicates", changeset.Count(diff.Duplicate)),
+		attribute.Int("map.updated", changeset.Count(diff.Update)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	applied := history.Diff{}
+	defer trail.flush(mapSyncContext)
+
+	for _, op := range changeset.Of(diff.Create) {
+		_, err := mapSyncContext.HAProxyClient.CreateMapEntrie(ctx, &op.Entrie, mapName)
+		if err != nil {
+			return applied, &entrieError{Operation: "created", Key: op.Entrie.Key, Err: err}
+		}
+		applied.Created = append(applied.Created, op.Entrie)
+		trail.add(audit.ActionCreate, mapName, op.Entrie.Key, "", op.Entrie.Value)
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("created", mapName)).Inc()
+	}
+
+	for _, op := range changeset.Of(diff.Delete) {
+		_, err := mapSyncContext.HAProxyClient.DeleteMapEntrie(ctx, &op.Entrie, mapName)
+		if err != nil {
+			return applied, &entrieError{Operation: "deleted", Key: op.Entrie.Key, Err: err}
+		}
+		applied.Deleted = append(applied.Deleted, op.Entrie)
+		trail.add(audit.ActionDelete, mapName, op.Entrie.Key, op.OldValue, "")
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("deleted", mapName)).Inc()
+	}
+
+	for _, op := range changeset.Of(diff.Duplicate) {
+		err := mapSyncContext.HAProxyClient.DeleteMapEntrieById(ctx, op.Entrie.Id, mapName)
+		if err != nil {
+			return applied, &entrieError{Operation: "deduplicated", Key: op.Entrie.Key, Err: err}
+		}
+		applied.Duplicates = append(applied.Duplicates, op.Entrie)
+		trail.add(audit.ActionDelete, mapName, op.Entrie.Key, op.OldValue, "")
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("deleted", mapName)).Inc()
+	}
+
+	for _, op := range changeset.Of(diff.Update) {
+		_, err := mapSyncContext.HAProxyClient.UpdateMapEntrie(ctx, &op.Entrie, mapName)
+		if err != nil {
+			return applied, &entrieError{Operation: "updated", Key: op.Entrie.Key, Err: err}
+		}
+		applied.Updated = append(applied.Updated, op.Entrie)
+		trail.add(audit.ActionUpdate, mapName, op.Entrie.Key, op.OldValue, op.Entrie.Value)
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("updated", mapName)).Inc()
+	}
+
+	return applied, nil
+}
+
+// replaceEntries replaces every entry of the map with the desired ones in a
+// single step, so that the changeset is either fully applied or not at all.
+func replaceEntries(ctx context.Context, mapSyncContext *client.MapSyncProxyAPI, mapName string, desired []haproxy.MapEntrie, changeset *diff.Changeset, trail *auditTrail) (_ history.Diff, err error) {
+	ctx, span := tracing.Start(ctx, "map.apply",
+		attribute.String("map.name", mapName),
+		attribute.Bool("map.atomic", true),
+		attribute.Int("map.created", changeset.Count(diff.Create)),
+		attribute.Int("map.deleted", changeset.Count(diff.Delete)),
+		attribute.Int("map.duplicates", changeset.Count(diff.Duplicate)),
+		attribute.Int("map.updated", changeset.Count(diff.Update)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	if err := mapSyncContext.HAProxyClient.ReplaceMapEntries(ctx, mapName, desired); err != nil {
+		return history.Diff{}, fmt.Errorf("The '%s' map could not be replaced: %w", mapName, err)
+	}
+
+	applied := history.Diff{}
+	defer trail.flush(mapSyncContext)
+	for _, op := range changeset.Of(diff.Create) {
+		applied.Created = append(applied.Created, op.Entrie)
+		trail.add(audit.ActionCreate, mapName, op.Entrie.Key, "", op.Entrie.Value)
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("created", mapName)).Inc()
+	}
+	for _, op := range changeset.Of(diff.Delete) {
+		applied.Deleted = append(applied.Deleted, op.Entrie)
+		trail.add(audit.ActionDelete, mapName, op.Entrie.Key, op.OldValue, "")
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("deleted", mapName)).Inc()
+	}
+	for _, op := range changeset.Of(diff.Duplicate) {
+		applied.Duplicates = append(applied.Duplicates, op.Entrie)
+		trail.add(audit.ActionDelete, mapName, op.Entrie.Key, op.OldValue, "")
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("deleted", mapName)).Inc()
+	}
+	for _, op := range changeset.Of(diff.Update) {
+		applied.Updated = append(applied.Updated, op.Entrie)
+		trail.add(audit.ActionUpdate, mapName, op.Entrie.Key, op.OldValue, op.Entrie.Value)
+		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("updated", mapName)).Inc()
+	}
+	return applied, nil
+}
+
+// updatePendingExpirations sets the pending expirations gauge of a map from
+// the synchronized entries and the manual changes waiting for their expiry.
+func updatePendingExpirations(mapSyncContext *client.MapSyncProxyAPI, mapName string) {
+	pending := mapSyncContext.Expirations.Pending(mapName)
+	for _, change := range mapSyncContext.ManualEntries.List(mapName) {
+		if !change.ExpiresAt.IsZero() {
+			pending++
+		}
+	}
+	mapSyncContext.ServerMetrics.PendingExpirationsCount.With(prometheus.Labels{"map_name": mapName}).Set(float64(pending))
+}
+
+// recordRevision persists a revision when the map history is enabled and
+// returns its number, or 0 when nothing was recorded.
+func recordRevision(mapSyncContext *client.MapSyncProxyAPI, revision *history.Revision) int {
+	if mapSyncContext.HistoryStore == nil {
+		return 0
+	}
+
+	if err := mapSyncContext.HistoryStore.Save(revision); err != nil {
+		log.Error().Err(err).Msgf("The revision of the '%s' map could not be recorded.", revision.MapName)
+		return 0
+	}
+	return revision.Revision
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	encodingGzip = "gzip"
+	encodingZstd = "zstd"
+)
+
+// errSourceTooLarge is returned when a source file exceeds the maximum size.
+var errSourceTooLarge = errors.New("the source file exceeds the maximum size")
+
+// errDecompressedSourceTooLarge is returned when a compressed source file
+// expands beyond the maximum decompressed size.
+var errDecompressedSourceTooLarge = errors.New("the decompressed source file exceeds the maximum size")
+
+// sizeLimitedReader fails with err as soon as more than remaining bytes are
+// read.
+type sizeLimitedReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+// limitSourceSize fails the reads with err past maxSize bytes. A maxSize of
+// zero disables the limit.
+func limitSourceSize(r io.Reader, maxSize int64, err error) io.Reader {
+	if maxSize <= 0 {
+		return r
+	}
+	return &sizeLimitedReader{r: r, remaining: maxSize, err: err}
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.err
+	}
+	// Read one byte past the limit to tell a file of exactly the maximum
+	// size from a larger one.
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, l.err
+	}
+	return n, err
+}
+
+// sourceEncoding returns the compression of a source file, read from its
+// Content-Encoding or else from its extension, or "" when it is not
+// compressed.
+func sourceEncoding(name, contentEncoding string) string {
+	switch strings.ToLower(contentEncoding) {
+	case encodingGzip, "x-gzip":
+		return encodingGzip
+	case encodingZstd:
+		return encodingZstd
+	}
+
+	switch path.Ext(name) {
+	case ".gz":
+		return encodingGzip
+	case ".zst":
+		return encodingZstd
+	}
+	return ""
+}
+
+// isJSONSource tells whether a listed object is a JSON source file, possibly
+// compressed.
+func isJSONSource(name, contentType, contentEncoding string) bool {
+	if contentType == "application/json" {
+		return true
+	}
+	if sourceEncoding(name, contentEncoding) == "" {
+		return false
+	}
+	return path.Ext(strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".zst")) == ".json"
+}
+
+// decompressSource decompresses a source file with the given encoding. The
+// decompressed content is limited to maxSize bytes, so that a small
+// compressed file cannot expand without bounds.
+func decompressSource(r io.Reader, encoding string, maxSize int64) (io.ReadCloser, error) {
+	var decompressed io.ReadCloser
+	switch encoding {
+	case encodingGzip:
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		decompressed = reader
+	case encodingZstd:
+		options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if maxSize >= zstd.MinWindowSize {
+			// The window is allocated up front from the frame header, so it
+			// is bounded too.
+			options = append(options, zstd.WithDecoderMaxWindow(uint64(maxSize)))
+		}
+		reader, err := zstd.NewReader(r, options...)
+		if err != nil {
+			return nil, err
+		}
+		decompressed = &zstdReader{reader}
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{limitSourceSize(decompressed, maxSize, errDecompressedSourceTooLarge), decompressed}, nil
+}
+
+// zstdReader reports a window larger than the maximum decompressed size as an
+// oversized source file.
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	n, err := z.Decoder.Read(p)
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		err = errDecompressedSourceTooLarge
+	}
+	return n, err
+}
+
+func (z *zstdReader) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// decodeSource decompresses a source file when encoding is not empty, and
+// decodes its entries.
+func decodeSource(ctx context.Context, content io.Reader, encoding string, maxDecompressedSize int64) (_ []haproxy.MapEntrie, err error) {
+	_, span := tracing.Start(ctx, "source.decode", attribute.String("source.encoding", encoding))
+	defer func() { tracing.End(span, err) }()
+
+	if encoding != "" {
+		decompressed, err := decompressSource(content, encoding, maxDecompressedSize)
+		if err != nil {
+			return nil, err
+		}
+		defer decompressed.Close()
+		content = decompressed
+	}
+
+	mapEntries, err := decodeMapEntries(content)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("source.entries", len(mapEntries)))
+	return mapEntries, nil
+}
+
+// decodeMapEntries decodes a JSON array of map entries one element at a time,
+// so that the raw content is never held in memory. The whole reader is
+// consumed, and anything after the array is rejected.
+func decodeMapEntries(r io.Reader) ([]haproxy.MapEntrie, error) {
+	decoder := json.NewDecoder(r)
+
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	var mapEntries []haproxy.MapEntrie
+	if token != nil {
+		if delim, ok := token.(json.Delim); !ok || delim != '[' {
+			return nil, fmt.Errorf("expected a JSON array of map entries, got %v", token)
+		}
+		for decoder.More() {
+			var entrie haproxy.MapEntrie
+			if err := decoder.Decode(&entrie); err != nil {
+				return nil, err
+			}
+			mapEntries = append(mapEntries, entrie)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		if err == nil {
+			err = errors.New("unexpected data after the JSON array of map entries")
+		}
+		return nil, err
+	}
+	return mapEntries, nil
+}
@@ -1,22 +1,56 @@
 package handlers
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/labstack/echo/v4"
 	"github.com/matthisholleville/mapsyncproxy/api/client"
 	"github.com/matthisholleville/mapsyncproxy/pkg/gcs"
 	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/history"
+	"github.com/matthisholleville/mapsyncproxy/pkg/signature"
+	"github.com/matthisholleville/mapsyncproxy/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type SynchronizeRequestBody struct {
 	BucketName     string `json:"bucket_name" validate:"required,bucket_name"`
 	BucketFileName string `json:"bucket_file_name" validate:"required,bucket_file_name"`
+	// MergeStrategy settles keys defined in several files when BucketFileName selects several objects.
+	// One of reject (default), first-wins, last-wins, last-updated or priority.
+	MergeStrategy string `json:"merge_strategy" enums:"reject,first-wins,last-wins,last-updated,priority"`
+	// MergePriority lists filename prefixes from the highest to the lowest priority, used by the priority strategy.
+	MergePriority []string `json:"merge_priority"`
+	// BucketPrefix restricts the listing to objects under this prefix when BucketFileName is "*" or a glob pattern.
+	BucketPrefix string `json:"bucket_prefix"`
+	// Exclude lists glob patterns of objects to skip when several files are selected.
+	Exclude []string `json:"exclude"`
+	// PinnedVersions maps object names to the version that must be synchronized.
+	// The synchronization fails if the live object no longer matches.
+	PinnedVersions map[string]ObjectVersion `json:"pinned_versions"`
+	// RequireSignature rejects source objects without a valid detached signature,
+	// stored in the "signature" metadata or in a "<object>.sig" object.
+	RequireSignature bool `json:"require_signature"`
+	// PreserveManualEntries keeps the entries changed through the entries API
+	// since the previous synchronization instead of overriding them.
+	PreserveManualEntries bool `json:"preserve_manual_entries"`
+	// AtomicReplace replaces every entry of the map at once instead of applying
+	// each change, so that HAProxy never serves a partially synchronized map.
+	// Only supported by the Runtime API backend.
+	AtomicReplace bool `json:"atomic_replace"`
 }
 
 // Synchronize godoc
@@ -29,8 +63,13 @@ type SynchronizeRequestBody struct {
 //	@Param		_			body	SynchronizeRequestBody	true	"Data of the synchronisation endpoint"
 //	@Param		map_name	path	string				true	"Map name"//
 //
-// @Success		200
+// @Success		200	{object}	SynchronizeReport
+// @Failure		409	{object}	KeyConflictResponse	"Keys defined in several files and not settled by the merge strategy"
+// @Failure		412		"Pinned version mismatch"
+// @Failure		422	{object}	KeyConflictResponse	"Duplicate keys in a file, missing or invalid signature, or oversized file"
 // @Failure		500		"Internal Server Error"
+// @Failure		501		"Atomic replace not supported by the HAProxy backend"
+// @Failure		504	{object}	SynchronizeReport	"Canceled or phase deadline exceeded"
 // @Router			/v1/map/{map_name}/synchronize [post]
 func Synchronize(c echo.Context) (err error) {
 
@@ -48,160 +87,286 @@ func Synchronize(c echo.Context) (err error) {
 		return c.JSON(http.StatusBadRequest, jsonResponse("Error reading JSON request body."))
 	}
 
+	if !isValidMergeStrategy(requestBody.MergeStrategy) {
+		return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Unknown merge strategy '%s'.", requestBody.MergeStrategy)))
+	}
+
+	if requestBody.AtomicReplace {
+		if replacer, ok := mapSyncContext.HAProxyClient.(haproxy.AtomicReplacer); !ok || !replacer.ReplacesAtomically() {
+			return c.JSON(http.StatusNotImplemented, jsonResponse("The HAProxy backend cannot replace a map atomically."))
+		}
+	}
+
+	var verifier *signature.Verifier
+	if requestBody.RequireSignature {
+		if mapSyncContext.SignatureVerifier == nil {
+			return c.JSON(http.StatusBadRequest, jsonResponse("No signature public key is configured."))
+		}
+		verifier = mapSyncContext.SignatureVerifier
+	}
+
+	for _, pattern := range append([]string{requestBody.BucketFileName}, requestBody.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return c.JSON(http.StatusBadRequest, jsonResponse(fmt.Sprintf("Invalid pattern '%s'.", pattern)))
+		}
+	}
+
 	mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("processed", mapName)).Inc()
+	defer recordSyncStatus(c, mapSyncContext, mapName, history.OriginSynchronize, time.Now())
 
 	gcsEntries := &[]haproxy.MapEntrie{}
+	gcsFiles := []sourceFile{}
+	trail := newAuditTrail(c, mapSyncContext)
+
+	ctx := c.Request().Context()
+	downloadCtx, cancelDownload := withPhaseTimeout(ctx, mapSyncContext.SyncTimeouts.Download)
+	defer cancelDownload()
 
-	if requestBody.BucketFileName == "*" {
-		log.Info().Msgf("Multiple GCS files from %s bucket will be downloaded.", requestBody.BucketName)
+	if isGlobPattern(requestBody.BucketFileName) {
+		log.Info().Msgf("Multiple GCS files matching %s from %s bucket will be downloaded.", requestBody.BucketFileName, requestBody.BucketName)
 		// Get MapEntries files from GCS
-		gcsEntries, err = downloadMultipleFiles(mapSyncContext.GCSClientWrapper, requestBody.BucketName)
+		downloadStartedAt := time.Now()
+		gcsFiles, err = downloadMultipleFiles(downloadCtx, mapSyncContext.GCSClientWrapper, requestBody.BucketName, requestBody.BucketPrefix, requestBody.BucketFileName, requestBody.Exclude, requestBody.PinnedVersions, mapSyncContext.SourceLimits, verifier)
+		observePhase(mapSyncContext, mapName, phaseDownload, time.Since(downloadStartedAt))
+		if isCanceled(err) {
+			return canceledSync(c, mapSyncContext, mapName, &canceledError{Phase: phaseDownload, Err: err}, &reconciliation{})
+		}
+		if status := sourceErrorStatus(err); status != 0 {
+			log.Debug().Err(err).Msg("A GCS file could not be verified.")
+			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
+			return c.JSON(status, jsonResponse(fmt.Sprintf("A GCS file could not be verified: %s.", err)))
+		}
 		if err != nil {
 			log.Debug().Err(err).Msg("The GCS files could not be listed.")
 			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
 			return c.JSON(http.StatusInternalServerError, jsonResponse("The GCS files could not be listed."))
 		}
 
+		if conflicts := duplicateKeyConflicts(gcsFiles); len(conflicts) > 0 {
+			log.Debug().Msg("The GCS files contain duplicate keys.")
+			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
+			return c.JSON(http.StatusUnprocessableEntity, KeyConflictResponse{Status: "The GCS files contain duplicate keys.", Conflicts: conflicts})
+		}
+
+		mergedEntries, owners, err := mergeSourceFiles(gcsFiles, requestBody.MergeStrategy, requestBody.MergePriority)
+		conflictErr := &keyConflictError{}
+		if errors.As(err, &conflictErr) {
+			log.Debug().Err(err).Msg("The GCS files could not be merged.")
+			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
+			return c.JSON(http.StatusConflict, KeyConflictResponse{Status: "The GCS files define conflicting keys.", Conflicts: conflictErr.Conflicts})
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("The GCS files could not be merged.")
+			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
+			return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The GCS files could not be merged: %s.", err)))
+		}
+		gcsEntries = &mergedEntries
+		trail.setSources(gcsFiles, owners)
+
 	} else {
 		log.Info().Msgf("The GCS file %s from the %s bucket will be downloaded", requestBody.BucketFileName, requestBody.BucketName)
 		// Get MapEntries file from GCS
-		gcsEntries, err = getGCSJsonFile(mapSyncContext.GCSClientWrapper, requestBody.BucketName, requestBody.BucketFileName)
+		downloadStartedAt := time.Now()
+		gcsFile, err := getGCSJsonFile(downloadCtx, mapSyncContext.GCSClientWrapper, requestBody.BucketName, requestBody.BucketFileName, requestBody.PinnedVersions[requestBody.BucketFileName], mapSyncContext.SourceLimits, verifier)
+		observePhase(mapSyncContext, mapName, phaseDownload, time.Since(downloadStartedAt))
+		if isCanceled(err) {
+			return canceledSync(c, mapSyncContext, mapName, &canceledError{Phase: phaseDownload, Err: err}, &reconciliation{})
+		}
+		if status := sourceErrorStatus(err); status != 0 {
+			log.Debug().Err(err).Msg("The GCS file could not be verified.")
+			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
+			return c.JSON(status, jsonResponse(fmt.Sprintf("The GCS file could not be verified: %s.", err)))
+		}
 		if err != nil {
 			log.Debug().Err(err).Msg("The GCS file could not be downloaded or interpreted.")
 			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
 			return c.JSON(http.StatusInternalServerError, jsonResponse("The GCS file could not be downloaded or interpreted."))
 		}
+		gcsFiles = append(gcsFiles, *gcsFile)
+
+		// Check if duplicate keys
+		if conflicts := duplicateKeyConflicts(gcsFiles); len(conflicts) > 0 {
+			log.Debug().Msg("The GCS file contains duplicate keys.")
+			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
+			return c.JSON(http.StatusUnprocessableEntity, KeyConflictResponse{Status: "The GCS file contains duplicate keys.", Conflicts: conflicts})
+		}
+		gcsEntries = &gcsFile.Entries
+		trail.setSources(gcsFiles, nil)
 
 	}
 
-	// Check if duplicate keys
-	if hasDuplicateKeys(*gcsEntries) {
-		log.Debug().Msg("The GCS file could not be downloaded or interpreted.")
-		mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
-		return c.JSON(http.StatusInternalServerError, jsonResponse("The GCS file contains duplicate keys."))
+	desiredEntries := *gcsEntries
+	manualChanges := mapSyncContext.ManualEntries.List(mapName)
+	if requestBody.PreserveManualEntries {
+		desiredEntries = applyManualChanges(desiredEntries, manualChanges)
 	}
 
-	// Get HAProxy entries from map
-	haproxyEntries, err := mapSyncContext.HAProxyClient.GetMapEntries(mapName)
+	result, err := reconcileMap(ctx, mapSyncContext, mapName, desiredEntries, requestBody.AtomicReplace, trail)
+	canceled := &canceledError{}
+	if errors.As(err, &canceled) {
+		return canceledSync(c, mapSyncContext, mapName, canceled, result)
+	}
 	if err != nil {
-		log.Debug().Err(err).Msg("The entries from the HAProxy Map file could not be retrieved or interpreted.")
+		log.Debug().Err(err).Msg("The HAProxy Map file could not be synchronized.")
 		mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
-		return c.JSON(http.StatusInternalServerError, jsonResponse("The entries from the HAProxy Map file could not be retrieved or interpreted."))
-	}
-
-	// If Not Exist CreateMap
-	entriesToBeCreated := findDifference(*gcsEntries, *haproxyEntries, "")
-	for _, entrie := range entriesToBeCreated {
-		_, err = mapSyncContext.HAProxyClient.CreateMapEntrie(&entrie, mapName)
-		if err != nil {
-			log.Debug().Err(err).Msgf("The '%s' entry could not be created.", entrie.Key)
-			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
-			return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be created.", entrie.Key)))
-		}
-		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("created", mapName)).Inc()
+		return c.JSON(http.StatusInternalServerError, jsonResponse(err.Error()))
 	}
 
-	// If Not Exist in gcs file DeleteMap
-	entriesToBeDeleted := findDifference(*haproxyEntries, *gcsEntries, "")
-	for _, entrie := range entriesToBeDeleted {
-		_, err = mapSyncContext.HAProxyClient.DeleteMapEntrie(&entrie, mapName)
-		if err != nil {
-			log.Debug().Err(err).Msgf("The '%s' entry could not be deleted.", entrie.Key)
-			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
-			return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be deleted.", entrie.Key)))
-		}
-		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("deleted", mapName)).Inc()
+	if !requestBody.PreserveManualEntries {
+		mapSyncContext.ManualEntries.Clear(mapName)
 	}
+	updatePendingExpirations(mapSyncContext, mapName)
 
-	// If Exist and not already processed UpdateMap
-	entriesAlreadyProcessed := append(entriesToBeCreated, entriesToBeDeleted...)
-	entriesNotProcessed := findDifference(*gcsEntries, *&entriesAlreadyProcessed, "")
-	entriesToBeUpdated := findDifference(entriesNotProcessed, *haproxyEntries, "full")
-	for _, entrie := range entriesToBeUpdated {
-		_, err = mapSyncContext.HAProxyClient.UpdateMapEntrie(&entrie, mapName)
-		if err != nil {
-			log.Debug().Err(err).Msgf("The '%s' entry could not be updated.", entrie.Key)
-			mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("error", mapName)).Inc()
-			return c.JSON(http.StatusInternalServerError, jsonResponse(fmt.Sprintf("The '%s' entry could not be updated.", entrie.Key)))
-		}
-		mapSyncContext.ServerMetrics.MapEntriesTotalCount.With(setMetricsStatusLabels("updated", mapName)).Inc()
-	}
+	revision := recordRevision(mapSyncContext, &history.Revision{
+		MapName:  mapName,
+		Origin:   history.OriginSynchronize,
+		Snapshot: result.Snapshot,
+		Diff:     result.Diff,
+	})
 
 	// Return success
-	log.Info().Msgf("Synchronization success. %d created - %d updated - %d deleted", len(entriesToBeCreated), len(entriesToBeUpdated), len(entriesToBeDeleted))
+	log.Info().Msgf("Synchronization success. %d created - %d updated - %d deleted - %d duplicates", len(result.Diff.Created), len(result.Diff.Updated), len(result.Diff.Deleted), len(result.Diff.Duplicates))
 	mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("success", mapName)).Inc()
-	return c.JSON(http.StatusOK, jsonResponse("synchronization success."))
+	mapSyncContext.ServerMetrics.LastSuccessfulSyncTimestamp.With(prometheus.Labels{"map_name": mapName}).SetToCurrentTime()
+	return c.JSON(http.StatusOK, SynchronizeReport{
+		Status:        "synchronization success.",
+		Created:       len(result.Diff.Created),
+		Updated:       len(result.Diff.Updated),
+		Deleted:       len(result.Diff.Deleted),
+		Unchanged:     result.Unchanged,
+		Duplicates:    result.Diff.Duplicates,
+		Revision:      revision,
+		Sources:       sourceReports(gcsFiles),
+		ManualEntries: manualEntrieReports(manualChanges, requestBody.PreserveManualEntries),
+	})
 }
 
-func downloadMultipleFiles(g *gcs.GCSClientWrapper, bucketName string) (*[]haproxy.MapEntrie, error) {
-	gcsFiles, err := g.ListFiles(bucketName)
+func downloadMultipleFiles(ctx context.Context, g gcs.Storage, bucketName, prefix, pattern string, exclude []string, pinnedVersions map[string]ObjectVersion, limits client.SourceLimits, verifier *signature.Verifier) ([]sourceFile, error) {
+	gcsFiles, err := g.ListFiles(ctx, bucketName, listingPrefix(prefix, pattern))
 	if err != nil {
 		return nil, err
 	}
-	result := []haproxy.MapEntrie{}
+	result := []sourceFile{}
 	for _, file := range *gcsFiles {
-		if file.ContentType == "application/json" {
-			gcsEntries, err := getGCSJsonFile(g, bucketName, file.Name)
+		if !isSelected(file.Name, pattern, exclude) || strings.HasSuffix(file.Name, signatureSuffix) {
+			continue
+		}
+		if isJSONSource(file.Name, file.ContentType, file.ContentEncoding) {
+			gcsFile, err := getGCSJsonFile(ctx, g, bucketName, file.Name, pinnedVersions[file.Name], limits, verifier)
 			if err != nil {
 				return nil, err
 			}
-			log.Info().Msgf("%s file downloaded successfully. %d entrie(s) found", file.Name, len(*gcsEntries))
-			result = append(result, *gcsEntries...)
+			log.Info().Msgf("%s file downloaded successfully. %d entrie(s) found", file.Name, len(gcsFile.Entries))
+			gcsFile.Updated = file.Updated
+			result = append(result, *gcsFile)
 		}
 
 	}
-	return &result, nil
+
+	for name := range pinnedVersions {
+		if !containsSourceFile(result, name) {
+			return nil, fmt.Errorf("pinned object %s was not selected: %w", name, gcs.ErrVersionMismatch)
+		}
+	}
+	return result, nil
 }
 
-func getGCSJsonFile(g *gcs.GCSClientWrapper, bucketName, fileName string) (*[]haproxy.MapEntrie, error) {
-	rc, err := g.DownloadFile(bucketName, fileName)
+// getGCSJsonFile downloads and decodes a source file within the size limits.
+// A gzip or zstd compressed file is decompressed before it is decoded, while
+// its pinned hash and signature cover the stored content. When verifier is not
+// nil, the content must carry a valid detached signature.
+func getGCSJsonFile(ctx context.Context, g gcs.Storage, bucketName, fileName string, pinnedVersion ObjectVersion, limits client.SourceLimits, verifier *signature.Verifier) (_ *sourceFile, err error) {
+	ctx, span := tracing.Start(ctx, "source.download", attribute.String("gcs.bucket", bucketName), attribute.String("gcs.object", fileName))
+	defer func() { tracing.End(span, err) }()
+
+	rc, err := g.DownloadFile(ctx, bucketName, fileName, pinnedVersion.Generation)
 	if err != nil {
 		log.Err(err).Msgf("Unable to download %s", fileName)
 		return nil, err
 	}
-	data, err := io.ReadAll(rc)
-	if err != nil {
+	defer rc.Close()
+	span.SetAttributes(attribute.Int64("gcs.generation", rc.Attrs.Generation), attribute.Int64("gcs.size", rc.Attrs.Size))
+	if limits.MaxSize > 0 && rc.Attrs.Size > limits.MaxSize {
+		err = fmt.Errorf("%s: %d bytes: %w", fileName, rc.Attrs.Size, errSourceTooLarge)
 		log.Err(err).Msgf("Unable to read %s", fileName)
 		return nil, err
 	}
-	var mapEntries []haproxy.MapEntrie
-	err = json.Unmarshal(data, &mapEntries)
+
+	checksum := sha256.New()
+	content := io.TeeReader(limitSourceSize(rc, limits.MaxSize, errSourceTooLarge), checksum)
+	if verifier != nil {
+		// The signature covers the raw content, which must be read in full
+		// before anything is decoded.
+		data, err := io.ReadAll(content)
+		if err != nil {
+			log.Err(err).Msgf("Unable to read %s", fileName)
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		sig, err := getSignature(ctx, g, bucketName, fileName, rc.Attrs.Generation)
+		if err == nil {
+			err = verifier.Verify(data, sig)
+		}
+		if err != nil {
+			log.Err(err).Msgf("Unable to verify the signature of %s", fileName)
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		content = bytes.NewReader(data)
+	}
+	mapEntries, err := decodeSource(ctx, content, sourceEncoding(fileName, rc.Attrs.ContentEncoding), limits.MaxDecompressedSize)
 	if err != nil {
-		log.Err(err).Msgf("Unable to Unmarshal %s", fileName)
+		log.Err(err).Msgf("Unable to decode %s", fileName)
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	contentHash := hex.EncodeToString(checksum.Sum(nil))
+	if pinnedVersion.SHA256 != "" && !strings.EqualFold(pinnedVersion.SHA256, contentHash) {
+		err = fmt.Errorf("%s: sha256 %s: %w", fileName, contentHash, gcs.ErrVersionMismatch)
+		log.Err(err).Msgf("Unable to verify %s", fileName)
 		return nil, err
 	}
-	return &mapEntries, nil
+	return &sourceFile{
+		Name:       fileName,
+		Generation: rc.Attrs.Generation,
+		SHA256:     contentHash,
+		Entries:    mapEntries,
+	}, nil
 
 }
 
-func findDifference(array1, array2 []haproxy.MapEntrie, diffType string) []haproxy.MapEntrie {
-	difference := []haproxy.MapEntrie{}
-
-	map2 := make(map[string]haproxy.MapEntrie)
-	for _, item := range array2 {
-		map2[item.Key] = item
+// getSignature returns the detached signature of an object generation, read
+// from its metadata or from the "<object>.sig" object next to it.
+func getSignature(ctx context.Context, g gcs.Storage, bucketName, fileName string, generation int64) ([]byte, error) {
+	metadata, err := g.GetMetadata(ctx, bucketName, fileName, generation)
+	if err != nil {
+		return nil, err
+	}
+	if sig, exists := metadata[signatureMetadataKey]; exists {
+		return []byte(sig), nil
 	}
 
-	if diffType == "full" {
-		for _, item := range array1 {
-			if _, exists := map2[item.Key]; exists {
-				if map2[item.Key].Value != item.Value {
-					difference = append(difference, item)
-				}
-			} else {
-				difference = append(difference, item)
-			}
-		}
-	} else {
-		for _, item := range array1 {
-			if _, exists := map2[item.Key]; !exists {
-				difference = append(difference, item)
-			}
-		}
-
+	rc, err := g.DownloadFile(ctx, bucketName, fileName+signatureSuffix, 0)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, signature.ErrMissingSignature
 	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
 
-	return difference
+// canceledSync reports how far a synchronization stopped by the caller or by
+// a phase deadline got. The changes applied before the cancellation are kept.
+func canceledSync(c echo.Context, mapSyncContext *client.MapSyncProxyAPI, mapName string, canceled *canceledError, result *reconciliation) error {
+	log.Warn().Err(canceled.Err).Msgf("The synchronization of the '%s' map was canceled during the %s phase. %d created - %d updated - %d deleted", mapName, canceled.Phase, len(result.Diff.Created), len(result.Diff.Updated), len(result.Diff.Deleted))
+	mapSyncContext.ServerMetrics.SynchronizationTotalCount.With(setMetricsStatusLabels("canceled", mapName)).Inc()
+	return c.JSON(http.StatusGatewayTimeout, SynchronizeReport{
+		Status:     canceled.Error(),
+		Phase:      canceled.Phase,
+		Created:    len(result.Diff.Created),
+		Updated:    len(result.Diff.Updated),
+		Deleted:    len(result.Diff.Deleted),
+		Duplicates: result.Diff.Duplicates,
+	})
 }
 
 func setMetricsStatusLabels(status, mapName string) prometheus.Labels {
@@ -0,0 +1,231 @@
+package handlers_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/api/handlers"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy/haproxytest"
+)
+
+func synchronizeBody(fileName, extra string) string {
+	return fmt.Sprintf(`{"bucket_name":%q, "bucket_file_name":%q%s}`, testBucket, fileName, extra)
+}
+
+func TestSynchronize(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10", "/login", "5", "/old", "1")...)
+	s.putSource(t, "rate-limits.json", haproxytest.Entries("/api", "10", "/login", "20", "/new", "3"))
+
+	rec := s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", ""))
+	expectStatus(t, rec, http.StatusOK)
+
+	report := handlers.SynchronizeReport{}
+	decode(t, rec, &report)
+	if report.Created != 1 || report.Updated != 1 || report.Deleted != 1 || report.Unchanged != 1 {
+		t.Errorf("report = %+v, want 1 created, 1 updated, 1 deleted and 1 unchanged", report)
+	}
+	if len(report.Sources) != 1 || report.Sources[0].Name != "rate-limits.json" || report.Sources[0].Entries != 3 {
+		t.Errorf("sources = %+v, want rate-limits.json with 3 entries", report.Sources)
+	}
+
+	expected := haproxytest.Entries("/api", "10", "/login", "20", "/new", "3")
+	if got := s.liveEntries(t, "rate-limits"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("live entries = %v, want %v", got, expected)
+	}
+}
+
+func TestSynchronizeDeletesDuplicateLiveKeys(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10", "/api", "20")...)
+	s.putSource(t, "rate-limits.json", haproxytest.Entries("/api", "10"))
+
+	rec := s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", ""))
+	expectStatus(t, rec, http.StatusOK)
+
+	report := handlers.SynchronizeReport{}
+	decode(t, rec, &report)
+	if len(report.Duplicates) != 1 || report.Duplicates[0].Value != "20" {
+		t.Errorf("duplicates = %v, want the second /api entry", report.Duplicates)
+	}
+	if got, expected := s.liveEntries(t, "rate-limits"), haproxytest.Entries("/api", "10"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("live entries = %v, want %v", got, expected)
+	}
+}
+
+func TestSynchronizeAtomicReplace(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10", "/old", "1")...)
+	s.backend.FailOn("CreateMapEntrie", errors.New("entries must be replaced at once"))
+	s.putSource(t, "rate-limits.json", haproxytest.Entries("/api", "20", "/new", "3"))
+
+	rec := s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", `, "atomic_replace":true`))
+	expectStatus(t, rec, http.StatusOK)
+
+	report := handlers.SynchronizeReport{}
+	decode(t, rec, &report)
+	if report.Created != 1 || report.Updated != 1 || report.Deleted != 1 {
+		t.Errorf("report = %+v, want 1 created, 1 updated and 1 deleted", report)
+	}
+	if got, expected := s.liveEntries(t, "rate-limits"), haproxytest.Entries("/api", "20", "/new", "3"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("live entries = %v, want %v", got, expected)
+	}
+}
+
+func TestSynchronizeMergesSelectedFiles(t *testing.T) {
+	s := newTestServer(t)
+	s.backend.LoadMap("rate-limits")
+	s.putSource(t, "rate-limits/a.json", haproxytest.Entries("/api", "10"))
+	s.putSource(t, "rate-limits/b.json", haproxytest.Entries("/api", "20", "/login", "5"))
+	s.putSource(t, "other/c.json", haproxytest.Entries("/other", "1"))
+
+	rec := s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits/*.json", `, "merge_strategy":"last-wins"`))
+	expectStatus(t, rec, http.StatusOK)
+
+	if got, expected := s.liveEntries(t, "rate-limits"), haproxytest.Entries("/api", "20", "/login", "5"); !reflect.DeepEqual(got, expected) {
+		t.Errorf("live entries = %v, want %v", got, expected)
+	}
+}
+
+func TestSynchronizeKeyConflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		sources  map[string][]haproxy.MapEntrie
+		fileName string
+		code     int
+		files    []string
+	}{
+		{
+			name:     "duplicate key in a file",
+			sources:  map[string][]haproxy.MapEntrie{"rate-limits.json": haproxytest.Entries("/api", "10", "/api", "20")},
+			fileName: "rate-limits.json",
+			code:     http.StatusUnprocessableEntity,
+			files:    []string{"rate-limits.json"},
+		},
+		{
+			name: "key defined in several files",
+			sources: map[string][]haproxy.MapEntrie{
+				"rate-limits/a.json": haproxytest.Entries("/api", "10"),
+				"rate-limits/b.json": haproxytest.Entries("/api", "20"),
+			},
+			fileName: "rate-limits/*",
+			code:     http.StatusConflict,
+			files:    []string{"rate-limits/a.json", "rate-limits/b.json"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "1")...)
+			for name, entries := range test.sources {
+				s.putSource(t, name, entries)
+			}
+
+			rec := s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody(test.fileName, ""))
+			expectStatus(t, rec, test.code)
+
+			response := handlers.KeyConflictResponse{}
+			decode(t, rec, &response)
+			expected := []handlers.KeyConflict{{Key: "/api", Files: test.files}}
+			if !reflect.DeepEqual(response.Conflicts, expected) {
+				t.Errorf("conflicts = %+v, want %+v", response.Conflicts, expected)
+			}
+			if got, expected := s.liveEntries(t, "rate-limits"), haproxytest.Entries("/api", "1"); !reflect.DeepEqual(got, expected) {
+				t.Errorf("live entries = %v, want the map unchanged", got)
+			}
+		})
+	}
+}
+
+func TestSynchronizeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		prepare func(s *testServer)
+		code    int
+	}{
+		{
+			name: "unknown merge strategy",
+			body: synchronizeBody("*", `, "merge_strategy":"random"`),
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "missing source file",
+			body: synchronizeBody("missing.json", ""),
+			code: http.StatusInternalServerError,
+		},
+		{
+			name: "pinned version mismatch",
+			body: synchronizeBody("rate-limits.json", `, "pinned_versions":{"rate-limits.json":{"generation":42}}`),
+			code: http.StatusPreconditionFailed,
+		},
+		{
+			name:    "bucket listing failure",
+			body:    synchronizeBody("*", ""),
+			prepare: func(s *testServer) { s.bucket.FailOn("ListFiles", errors.New("permission denied")) },
+			code:    http.StatusInternalServerError,
+		},
+		{
+			name:    "backend failure",
+			body:    synchronizeBody("rate-limits.json", ""),
+			prepare: func(s *testServer) { s.backend.FailOn("CreateMapEntrie", errors.New("connection refused")) },
+			code:    http.StatusInternalServerError,
+		},
+		{
+			name: "apply deadline exceeded",
+			body: synchronizeBody("rate-limits.json", ""),
+			prepare: func(s *testServer) {
+				s.backend.SetLatency(50 * time.Millisecond)
+				s.api.SyncTimeouts.Apply = 10 * time.Millisecond
+			},
+			code: http.StatusGatewayTimeout,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.backend.LoadMap("rate-limits")
+			s.putSource(t, "rate-limits.json", haproxytest.Entries("/api", "10"))
+			if test.prepare != nil {
+				test.prepare(s)
+			}
+
+			rec := s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", test.body)
+			expectStatus(t, rec, test.code)
+		})
+	}
+}
+
+func TestSynchronizeManualEntries(t *testing.T) {
+	for _, preserve := range []bool{false, true} {
+		t.Run(fmt.Sprintf("preserve %t", preserve), func(t *testing.T) {
+			s := newTestServer(t)
+			s.backend.LoadMap("rate-limits", haproxytest.Entries("/api", "10")...)
+			s.putSource(t, "rate-limits.json", haproxytest.Entries("/api", "10"))
+			expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/entries", `{"key":"/manual", "value":"1"}`), http.StatusCreated)
+
+			rec := s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", fmt.Sprintf(`, "preserve_manual_entries":%t`, preserve)))
+			expectStatus(t, rec, http.StatusOK)
+
+			report := handlers.SynchronizeReport{}
+			decode(t, rec, &report)
+			expected, status := haproxytest.Entries("/api", "10"), "overridden"
+			if preserve {
+				expected, status = haproxytest.Entries("/api", "10", "/manual", "1"), "preserved"
+			}
+			if len(report.ManualEntries) != 1 || report.ManualEntries[0].Status != status {
+				t.Errorf("manual entries = %+v, want /manual %s", report.ManualEntries, status)
+			}
+			if got := s.liveEntries(t, "rate-limits"); !reflect.DeepEqual(got, expected) {
+				t.Errorf("live entries = %v, want %v", got, expected)
+			}
+		})
+	}
+}
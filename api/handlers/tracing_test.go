@@ -0,0 +1,208 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy/haproxytest"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// setupTracing records the spans in memory until the end of the test.
+func setupTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+// newTracedDataplane starts a v3 Dataplane API serving the live entries of
+// the rate-limits map, and answers createStatus to the creation of /new.
+func newTracedDataplane(t *testing.T, createStatus int) (*haproxy.Client, *haproxytest.Dataplane) {
+	t.Helper()
+
+	entries := "/v3/services/haproxy/runtime/maps/rate-limits/entries"
+	dataplane := haproxytest.NewDataplane(t, "", map[string]haproxytest.Fixture{
+		"GET " + entries:                                {Status: http.StatusOK, Body: `[{"id":"0x1","key":"/api","value":"10"},{"id":"0x2","key":"/login","value":"5"},{"id":"0x3","key":"/old","value":"1"}]`},
+		"POST " + entries + "?force_sync=true":          {Status: createStatus, Body: `{"key":"/new","value":"3"}`},
+		"PUT " + entries + "/%2Flogin?force_sync=true":  {Status: http.StatusOK, Body: `{"key":"/login","value":"20"}`},
+		"DELETE " + entries + "/%2Fold?force_sync=true": {Status: http.StatusNoContent},
+	})
+
+	client, err := haproxy.NewClient(haproxy.StaticCredentials("admin", "secret"), dataplane.Host(), nil, haproxy.APIVersion3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, dataplane
+}
+
+// spanTree indexes the recorded spans by name and by parent.
+type spanTree struct {
+	spans    tracetest.SpanStubs
+	children map[string][]string
+}
+
+func newSpanTree(spans tracetest.SpanStubs) *spanTree {
+	tree := &spanTree{spans: spans, children: make(map[string][]string)}
+	for _, span := range spans {
+		if parent := tree.byId(span.Parent.SpanID().String()); parent != nil {
+			tree.children[parent.Name] = append(tree.children[parent.Name], span.Name)
+		}
+	}
+	return tree
+}
+
+func (s *spanTree) byId(id string) *tracetest.SpanStub {
+	for i := range s.spans {
+		if s.spans[i].SpanContext.SpanID().String() == id {
+			return &s.spans[i]
+		}
+	}
+	return nil
+}
+
+func (s *spanTree) byName(t *testing.T, name string) *tracetest.SpanStub {
+	t.Helper()
+
+	for i := range s.spans {
+		if s.spans[i].Name == name {
+			return &s.spans[i]
+		}
+	}
+	t.Fatalf("no %s span was recorded", name)
+	return nil
+}
+
+func expectChildren(t *testing.T, tree *spanTree, parent string, expected ...string) {
+	t.Helper()
+
+	got := strings.Join(tree.children[parent], ", ")
+	if got != strings.Join(expected, ", ") {
+		t.Errorf("children of %s = [%s], want [%s]", parent, got, strings.Join(expected, ", "))
+	}
+}
+
+func expectAttributes(t *testing.T, span *tracetest.SpanStub, expected ...attribute.KeyValue) {
+	t.Helper()
+
+	attributes := attribute.NewSet(span.Attributes...)
+	for _, attr := range expected {
+		value, exists := attributes.Value(attr.Key)
+		if !exists || value != attr.Value {
+			t.Errorf("%s attribute %s = %v, want %v", span.Name, attr.Key, value.Emit(), attr.Value.Emit())
+		}
+	}
+}
+
+func TestSynchronizeSpans(t *testing.T) {
+	exporter := setupTracing(t)
+	s := newTestServer(t)
+	client, dataplane := newTracedDataplane(t, http.StatusCreated)
+	s.api.HAProxyClient = client
+	s.api.Echo.Use(otelecho.Middleware("mapsyncproxy"))
+	source := s.putSource(t, "rate-limits.json", haproxytest.Entries("/api", "10", "/login", "20", "/new", "3"))
+
+	expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", "")), http.StatusOK)
+
+	tree := newSpanTree(exporter.GetSpans())
+	root := tree.byName(t, "/v1/map/:mapName/synchronize")
+	if root.Parent.IsValid() {
+		t.Errorf("the HTTP span has a parent %s", root.Parent.SpanID())
+	}
+	for _, span := range tree.spans {
+		if span.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Errorf("%s span belongs to another trace", span.Name)
+		}
+	}
+
+	expectChildren(t, tree, root.Name, "source.download", "map.diff", "map.apply")
+	expectChildren(t, tree, "source.download", "source.decode")
+	expectChildren(t, tree, "map.diff", "dataplane.get_entries")
+	expectChildren(t, tree, "map.apply", "dataplane.create_entry", "dataplane.delete_entry", "dataplane.update_entry")
+
+	expectAttributes(t, tree.byName(t, "source.download"),
+		attribute.String("gcs.bucket", testBucket),
+		attribute.String("gcs.object", "rate-limits.json"),
+		attribute.Int64("gcs.generation", source.Generation),
+		attribute.Int64("gcs.size", source.Size),
+	)
+	expectAttributes(t, tree.byName(t, "source.decode"), attribute.Int("source.entries", 3))
+	expectAttributes(t, tree.byName(t, "map.diff"),
+		attribute.String("map.name", "rate-limits"),
+		attribute.Int("map.desired_entries", 3),
+		attribute.Int("map.live_entries", 3),
+		attribute.Int("map.drift_entries", 3),
+	)
+	expectAttributes(t, tree.byName(t, "map.apply"),
+		attribute.String("map.name", "rate-limits"),
+		attribute.Int("map.created", 1),
+		attribute.Int("map.deleted", 1),
+		attribute.Int("map.updated", 1),
+		attribute.Int("map.duplicates", 0),
+	)
+	expectAttributes(t, tree.byName(t, "dataplane.get_entries"),
+		attribute.String("http.method", http.MethodGet),
+		attribute.Int("http.status_code", http.StatusOK),
+	)
+	expectAttributes(t, tree.byName(t, "dataplane.create_entry"),
+		attribute.String("http.method", http.MethodPost),
+		attribute.Int("http.status_code", http.StatusCreated),
+	)
+	for _, span := range tree.spans {
+		if span.Status.Code == codes.Error {
+			t.Errorf("%s span failed: %s", span.Name, span.Status.Description)
+		}
+	}
+
+	// The Dataplane API receives the trace context of its client span.
+	requests := dataplane.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("the Dataplane API received %v, want 4 requests", dataplane.RequestLines())
+	}
+	for _, request := range requests {
+		if traceparent := request.Header.Get("traceparent"); !strings.Contains(traceparent, root.SpanContext.TraceID().String()) {
+			t.Errorf("%s traceparent %q does not carry the trace %s", request.Line, traceparent, root.SpanContext.TraceID())
+		}
+	}
+}
+
+func TestSynchronizeSpansRecordErrors(t *testing.T) {
+	exporter := setupTracing(t)
+	s := newTestServer(t)
+	client, _ := newTracedDataplane(t, http.StatusServiceUnavailable)
+	s.api.HAProxyClient = client
+	s.api.Echo.Use(otelecho.Middleware("mapsyncproxy"))
+	s.putSource(t, "rate-limits.json", haproxytest.Entries("/api", "10", "/new", "3"))
+
+	expectStatus(t, s.do(http.MethodPost, "/v1/map/rate-limits/synchronize", synchronizeBody("rate-limits.json", "")), http.StatusInternalServerError)
+
+	tree := newSpanTree(exporter.GetSpans())
+	expectChildren(t, tree, "map.apply", "dataplane.create_entry")
+	for _, name := range []string{"dataplane.create_entry", "map.apply"} {
+		if span := tree.byName(t, name); span.Status.Code != codes.Error {
+			t.Errorf("%s span status = %v, want an error", name, span.Status.Code)
+		}
+	}
+	expectAttributes(t, tree.byName(t, "dataplane.create_entry"), attribute.Int("http.status_code", http.StatusServiceUnavailable))
+	if span := tree.byName(t, "map.diff"); span.Status.Code == codes.Error {
+		t.Errorf("map.diff span failed: %s", span.Status.Description)
+	}
+}
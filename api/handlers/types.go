@@ -1,5 +1,89 @@
 package handlers
 
+import (
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/status"
+)
+
 type Response struct {
 	Message string `json:"message"`
 }
+
+// ObjectVersion pins the version of a source object. Zero fields are not checked.
+type ObjectVersion struct {
+	Generation int64  `json:"generation"`
+	SHA256     string `json:"sha256"`
+}
+
+type MapEntrieRequestBody struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// TTL reverts the change once elapsed, e.g. "30m". Optional.
+	TTL string `json:"ttl"`
+	// ExpiresAt reverts the change at the given date. Optional, exclusive with TTL.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ManualEntrieReport describes an entry changed through the entries API since
+// the previous synchronization.
+type ManualEntrieReport struct {
+	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+	Deleted bool   `json:"deleted"`
+	Caller  string `json:"caller"`
+	// Status is "preserved" or "overridden".
+	Status string `json:"status"`
+}
+
+type SynchronizeReport struct {
+	Status  string `json:"status"`
+	Created int    `json:"created"`
+	Updated int    `json:"updated"`
+	Deleted int    `json:"deleted"`
+	// Unchanged is the number of live entries already matching the source.
+	Unchanged int `json:"unchanged"`
+	// Phase is the phase during which a canceled synchronization stopped.
+	Phase string `json:"phase,omitempty"`
+	// Duplicates lists the extra occurrences of live keys deleted by id.
+	Duplicates []haproxy.MapEntrie `json:"duplicates,omitempty"`
+	// Revision is the history revision recorded for this synchronization, if any.
+	Revision      int                  `json:"revision,omitempty"`
+	Sources       []SourceReport       `json:"sources,omitempty"`
+	ManualEntries []ManualEntrieReport `json:"manual_entries,omitempty"`
+}
+
+// KeyConflict names a key defined more than once and the source files
+// defining it.
+type KeyConflict struct {
+	Key   string   `json:"key"`
+	Files []string `json:"files"`
+}
+
+// KeyConflictResponse lists the keys that prevent a synchronization.
+type KeyConflictResponse struct {
+	Status    string        `json:"status"`
+	Conflicts []KeyConflict `json:"conflicts"`
+}
+
+type SourceReport struct {
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+	SHA256     string `json:"sha256"`
+	Entries    int    `json:"entries"`
+}
+
+type CreateMapRequestBody struct {
+	Name    string              `json:"name" validate:"required"`
+	Entries []haproxy.MapEntrie `json:"entries"`
+}
+
+type MapInfo struct {
+	Name    string `json:"name"`
+	File    string `json:"file"`
+	Id      string `json:"id"`
+	Entries int64  `json:"entries"`
+	// LastSync is the last synchronization known to mapSyncProxy, if any.
+	LastSync *status.SyncStatus `json:"last_sync,omitempty"`
+}
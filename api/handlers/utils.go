@@ -1,6 +1,27 @@
 package handlers
 
-import "github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/gcs"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/overrides"
+	"github.com/matthisholleville/mapsyncproxy/pkg/signature"
+)
+
+const (
+	globMetaCharacters   = "*?["
+	signatureSuffix      = ".sig"
+	signatureMetadataKey = "signature"
+	manualSource         = "api"
+	expirySource         = "expiry"
+	expiryCaller         = "mapsyncproxy"
+)
 
 func jsonResponse(message string) *map[string]string {
 	response := map[string]string{"status": message}
@@ -8,14 +29,157 @@ func jsonResponse(message string) *map[string]string {
 }
 
 func hasDuplicateKeys(objects []haproxy.MapEntrie) bool {
-	seen := make(map[string]bool)
+	return len(duplicateKeys(objects)) > 0
+}
+
+// duplicateKeys returns the keys appearing more than once, in the order of
+// their first duplicate.
+func duplicateKeys(objects []haproxy.MapEntrie) []string {
+	seen := make(map[string]int)
+	duplicates := []string{}
 
 	for _, obj := range objects {
-		if seen[obj.Key] {
-			return true
+		seen[obj.Key]++
+		if seen[obj.Key] == 2 {
+			duplicates = append(duplicates, obj.Key)
 		}
-		seen[obj.Key] = true
 	}
 
+	return duplicates
+}
+
+// isGlobPattern reports whether the bucket file name selects several objects.
+func isGlobPattern(fileName string) bool {
+	return strings.ContainsAny(fileName, globMetaCharacters)
+}
+
+// listingPrefix returns the prefix sent to the storage query. The literal part
+// of the pattern is used when it narrows the requested prefix.
+func listingPrefix(prefix, pattern string) string {
+	literal := pattern
+	if i := strings.IndexAny(pattern, globMetaCharacters); i >= 0 {
+		literal = pattern[:i]
+	}
+	if strings.HasPrefix(literal, prefix) {
+		return literal
+	}
+	return prefix
+}
+
+// isSelected reports whether an object matches the pattern and none of the
+// exclude patterns. A lone "*" selects every object.
+func isSelected(name, pattern string, exclude []string) bool {
+	if pattern != "*" {
+		if matched, _ := path.Match(pattern, name); !matched {
+			return false
+		}
+	}
+	for _, excludePattern := range exclude {
+		if matched, _ := path.Match(excludePattern, name); matched {
+			return false
+		}
+	}
+	return true
+}
+
+func containsSourceFile(files []sourceFile, name string) bool {
+	for _, file := range files {
+		if file.Name == name {
+			return true
+		}
+	}
 	return false
 }
+
+func sourceReports(files []sourceFile) []SourceReport {
+	reports := []SourceReport{}
+	for _, file := range files {
+		reports = append(reports, SourceReport{
+			Name:       file.Name,
+			Generation: file.Generation,
+			SHA256:     file.SHA256,
+			Entries:    len(file.Entries),
+		})
+	}
+	return reports
+}
+
+// sourceErrorStatus returns the HTTP status of a source file verification
+// failure, or 0 when err is not one.
+func sourceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gcs.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, signature.ErrMissingSignature), errors.Is(err, signature.ErrInvalidSignature), errors.Is(err, errSourceTooLarge), errors.Is(err, errDecompressedSourceTooLarge):
+		return http.StatusUnprocessableEntity
+	}
+	return 0
+}
+
+// applyManualChanges overlays the manual changes on the desired entries.
+func applyManualChanges(entries []haproxy.MapEntrie, changes []overrides.Change) []haproxy.MapEntrie {
+	if len(changes) == 0 {
+		return entries
+	}
+
+	pending := make(map[string]overrides.Change)
+	for _, change := range changes {
+		pending[change.Key] = change
+	}
+
+	result := []haproxy.MapEntrie{}
+	for _, entrie := range entries {
+		change, exists := pending[entrie.Key]
+		if !exists {
+			result = append(result, entrie)
+			continue
+		}
+		delete(pending, entrie.Key)
+		if !change.Deleted {
+			// The expiry of the manual change applies instead of the
+			// synchronized one.
+			entrie.Value = change.Value
+			entrie.ExpiresAt = nil
+			result = append(result, entrie)
+		}
+	}
+	for _, change := range changes {
+		if _, exists := pending[change.Key]; exists && !change.Deleted {
+			result = append(result, haproxy.MapEntrie{Key: change.Key, Value: change.Value})
+		}
+	}
+	return result
+}
+
+func manualEntrieReports(changes []overrides.Change, preserved bool) []ManualEntrieReport {
+	status := "overridden"
+	if preserved {
+		status = "preserved"
+	}
+
+	reports := []ManualEntrieReport{}
+	for _, change := range changes {
+		reports = append(reports, ManualEntrieReport{
+			Key:     change.Key,
+			Value:   change.Value,
+			Deleted: change.Deleted,
+			Caller:  change.Caller,
+			Status:  status,
+		})
+	}
+	return reports
+}
+
+// withPhaseTimeout bounds a synchronization phase. A zero timeout only
+// inherits the deadline of ctx.
+func withPhaseTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// isCanceled reports whether err comes from a canceled or expired context.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
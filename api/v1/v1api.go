@@ -20,6 +20,24 @@ func API(s *client.MapSyncProxyAPI) {
 	v1Api := s.Echo.Group("/v1")
 
 	// map endpoints
+	v1Api.GET("/maps", handlers.ListMaps)
+	v1Api.POST("/maps", handlers.CreateMap)
+	v1Api.POST("/map/:mapName/clear", handlers.ClearMap)
+	v1Api.DELETE("/map/:mapName", handlers.DeleteMap)
 	v1Api.POST("/map/:mapName/synchronize", handlers.Synchronize)
 	v1Api.GET("/map/:mapName/generate", handlers.GenerateJsonFromMap)
+
+	// entries endpoints
+	v1Api.POST("/map/:mapName/entries", handlers.CreateMapEntrie)
+	v1Api.GET("/map/:mapName/entries/:key", handlers.GetMapEntrie)
+	v1Api.PUT("/map/:mapName/entries/:key", handlers.UpdateMapEntrie)
+	v1Api.DELETE("/map/:mapName/entries/:key", handlers.DeleteMapEntrie)
+
+	// history endpoints
+	v1Api.GET("/map/:mapName/history", handlers.GetMapHistory)
+	v1Api.GET("/map/:mapName/history/:rev", handlers.GetMapRevision)
+	v1Api.POST("/map/:mapName/rollback/:rev", handlers.RollbackMap)
+
+	// audit endpoints
+	v1Api.GET("/audit", handlers.ListAuditEvents)
 }
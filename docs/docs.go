@@ -55,9 +55,91 @@ const docTemplate = `{
                 }
             }
         },
-        "/v1/map/{map_name}/generate": {
+        "/v1/audit": {
             "get": {
-                "description": "Generate json file from map file.",
+                "description": "List the audit events of map mutations, oldest first.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Audit"
+                ],
+                "summary": "List the audit events of map mutations.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Entry key",
+                        "name": "key",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Caller identity",
+                        "name": "caller",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "create, update or delete",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Request id",
+                        "name": "request_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 lower time bound",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC 3339 upper time bound",
+                        "name": "until",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of events, the most recent are kept",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/audit.Event"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid filter"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            }
+        },
+        "/v1/map/{map_name}": {
+            "delete": {
+                "description": "Delete a map file from the Dataplane storage. Maps referenced in the running configuration cannot be deleted.",
                 "consumes": [
                     "application/json"
                 ],
@@ -67,7 +149,7 @@ const docTemplate = `{
                 "tags": [
                     "Map"
                 ],
-                "summary": "Generate json file from map file.",
+                "summary": "Delete a map file.",
                 "parameters": [
                     {
                         "type": "string",
@@ -78,18 +160,30 @@ const docTemplate = `{
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK"
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid map name"
+                    },
+                    "404": {
+                        "description": "Map not found"
+                    },
+                    "409": {
+                        "description": "Map referenced in the running configuration"
                     },
                     "500": {
                         "description": "Internal Server Error"
+                    },
+                    "501": {
+                        "description": "Not supported by the HAProxy backend"
                     }
                 }
             }
         },
-        "/v1/map/{map_name}/synchronize": {
+        "/v1/map/{map_name}/clear": {
             "post": {
-                "description": "Synchronize GCS file to an HAProxy map file.",
+                "description": "Remove every entry of a runtime map.",
                 "consumes": [
                     "application/json"
                 ],
@@ -99,17 +193,242 @@ const docTemplate = `{
                 "tags": [
                     "Map"
                 ],
-                "summary": "Synchronize GCS file to an HAProxy map file.",
+                "summary": "Remove every entry of a map.",
                 "parameters": [
                     {
-                        "description": "Data of the synchronisation endpoint",
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid map name"
+                    },
+                    "404": {
+                        "description": "Map not found"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            }
+        },
+        "/v1/map/{map_name}/entries": {
+            "post": {
+                "description": "Create a map entry, kept until the next synchronization unless it is preserved.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Entries"
+                ],
+                "summary": "Create a map entry.",
+                "parameters": [
+                    {
+                        "description": "Entry to create",
                         "name": "_",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.SynchronizeRequestBody"
+                            "$ref": "#/definitions/handlers.MapEntrieRequestBody"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/haproxy.MapEntrie"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body"
+                    },
+                    "409": {
+                        "description": "Entry already exists"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            }
+        },
+        "/v1/map/{map_name}/entries/{key}": {
+            "get": {
+                "description": "Get a map entry. The key must be URL encoded.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Entries"
+                ],
+                "summary": "Get a map entry.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "URL encoded key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/haproxy.MapEntrie"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid map name or key"
+                    },
+                    "404": {
+                        "description": "Entry not found"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            },
+            "put": {
+                "description": "Update a map entry. With a TTL, the previous value is restored once it elapses.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Entries"
+                ],
+                "summary": "Update a map entry.",
+                "parameters": [
+                    {
+                        "description": "New value of the entry",
+                        "name": "_",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.MapEntrieRequestBody"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "URL encoded key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/haproxy.MapEntrie"
                         }
                     },
+                    "400": {
+                        "description": "Invalid request body"
+                    },
+                    "404": {
+                        "description": "Entry not found"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a map entry. The key must be URL encoded.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Entries"
+                ],
+                "summary": "Delete a map entry.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "URL encoded key",
+                        "name": "key",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid map name or key"
+                    },
+                    "404": {
+                        "description": "Entry not found"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            }
+        },
+        "/v1/map/{map_name}/generate": {
+            "get": {
+                "description": "Generate json file from map file.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Map"
+                ],
+                "summary": "Generate json file from map file.",
+                "parameters": [
                     {
                         "type": "string",
                         "description": "Map name",
@@ -127,20 +446,705 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "handlers.SynchronizeRequestBody": {
-            "type": "object",
-            "required": [
-                "bucket_file_name",
-                "bucket_name"
-            ],
-            "properties": {
-                "bucket_file_name": {
-                    "type": "string"
-                },
-                "bucket_name": {
+        },
+        "/v1/map/{map_name}/history": {
+            "get": {
+                "description": "List the recorded revisions of a map, without their snapshot.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "History"
+                ],
+                "summary": "List the recorded revisions of a map.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/history.Revision"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Map history is not enabled"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            }
+        },
+        "/v1/map/{map_name}/history/{rev}": {
+            "get": {
+                "description": "Get a recorded revision of a map with its snapshot and applied diff.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "History"
+                ],
+                "summary": "Get a recorded revision of a map.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Revision",
+                        "name": "rev",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/history.Revision"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid revision"
+                    },
+                    "404": {
+                        "description": "Revision not found"
+                    },
+                    "409": {
+                        "description": "Revision snapshot missing"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            }
+        },
+        "/v1/map/{map_name}/rollback/{rev}": {
+            "post": {
+                "description": "Restore the map as it was after a recorded revision, or before it with state=before.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "History"
+                ],
+                "summary": "Restore a recorded revision of a map.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Revision",
+                        "name": "rev",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "after (default) or before",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Keep the entries changed through the entries API since the previous synchronization",
+                        "name": "preserve_manual_entries",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SynchronizeReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid revision"
+                    },
+                    "404": {
+                        "description": "Revision not found"
+                    },
+                    "409": {
+                        "description": "Revision snapshot missing"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            }
+        },
+        "/v1/map/{map_name}/synchronize": {
+            "post": {
+                "description": "Synchronize GCS file to an HAProxy map file.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Map"
+                ],
+                "summary": "Synchronize GCS file to an HAProxy map file.",
+                "parameters": [
+                    {
+                        "description": "Data of the synchronisation endpoint",
+                        "name": "_",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SynchronizeRequestBody"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Map name",
+                        "name": "map_name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SynchronizeReport"
+                        }
+                    },
+                    "409": {
+                        "description": "Keys defined in several files and not settled by the merge strategy",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.KeyConflictResponse"
+                        }
+                    },
+                    "412": {
+                        "description": "Pinned version mismatch"
+                    },
+                    "422": {
+                        "description": "Duplicate keys in a file, missing or invalid signature, or oversized file",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.KeyConflictResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    },
+                    "501": {
+                        "description": "Atomic replace not supported by the HAProxy backend"
+                    },
+                    "504": {
+                        "description": "Canceled or phase deadline exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.SynchronizeReport"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/maps": {
+            "get": {
+                "description": "List the maps loaded in HAProxy with their entry count and last synchronization status.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Map"
+                ],
+                "summary": "List the maps loaded in HAProxy.",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.MapInfo"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a map file in the Dataplane storage. HAProxy loads it once a configuration referencing it is reloaded.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Map"
+                ],
+                "summary": "Create a map file.",
+                "parameters": [
+                    {
+                        "description": "Map to create",
+                        "name": "_",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.CreateMapRequestBody"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/haproxy.StorageMap"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request body"
+                    },
+                    "409": {
+                        "description": "Map already exists"
+                    },
+                    "500": {
+                        "description": "Internal Server Error"
+                    },
+                    "501": {
+                        "description": "Not supported by the HAProxy backend"
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "audit.Event": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "caller": {
+                    "type": "string"
+                },
+                "generation": {
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "map_name": {
+                    "type": "string"
+                },
+                "new_value": {
+                    "type": "string"
+                },
+                "old_value": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.CreateMapRequestBody": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/haproxy.MapEntrie"
+                    }
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.KeyConflict": {
+            "type": "object",
+            "properties": {
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "key": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.KeyConflictResponse": {
+            "type": "object",
+            "properties": {
+                "conflicts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.KeyConflict"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.ManualEntrieReport": {
+            "type": "object",
+            "properties": {
+                "caller": {
+                    "type": "string"
+                },
+                "deleted": {
+                    "type": "boolean"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "Status is \"preserved\" or \"overridden\".",
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.MapEntrieRequestBody": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "description": "ExpiresAt reverts the change at the given date. Optional, exclusive with TTL.",
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "ttl": {
+                    "description": "TTL reverts the change once elapsed, e.g. \"30m\". Optional.",
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.MapInfo": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "integer"
+                },
+                "file": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "last_sync": {
+                    "description": "LastSync is the last synchronization known to mapSyncProxy, if any.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/status.SyncStatus"
+                        }
+                    ]
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.ObjectVersion": {
+            "type": "object",
+            "properties": {
+                "generation": {
+                    "type": "integer"
+                },
+                "sha256": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.SourceReport": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "integer"
+                },
+                "generation": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "sha256": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.SynchronizeReport": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "deleted": {
+                    "type": "integer"
+                },
+                "duplicates": {
+                    "description": "Duplicates lists the extra occurrences of live keys deleted by id.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/haproxy.MapEntrie"
+                    }
+                },
+                "manual_entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.ManualEntrieReport"
+                    }
+                },
+                "phase": {
+                    "description": "Phase is the phase during which a canceled synchronization stopped.",
+                    "type": "string"
+                },
+                "revision": {
+                    "description": "Revision is the history revision recorded for this synchronization, if any.",
+                    "type": "integer"
+                },
+                "sources": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.SourceReport"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                },
+                "unchanged": {
+                    "description": "Unchanged is the number of live entries already matching the source.",
+                    "type": "integer"
+                },
+                "updated": {
+                    "type": "integer"
+                }
+            }
+        },
+        "handlers.SynchronizeRequestBody": {
+            "type": "object",
+            "required": [
+                "bucket_file_name",
+                "bucket_name"
+            ],
+            "properties": {
+                "atomic_replace": {
+                    "description": "AtomicReplace replaces every entry of the map at once instead of applying\neach change, so that HAProxy never serves a partially synchronized map.\nOnly supported by the Runtime API backend.",
+                    "type": "boolean"
+                },
+                "bucket_file_name": {
+                    "type": "string"
+                },
+                "bucket_name": {
+                    "type": "string"
+                },
+                "bucket_prefix": {
+                    "description": "BucketPrefix restricts the listing to objects under this prefix when BucketFileName is \"*\" or a glob pattern.",
+                    "type": "string"
+                },
+                "exclude": {
+                    "description": "Exclude lists glob patterns of objects to skip when several files are selected.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "merge_priority": {
+                    "description": "MergePriority lists filename prefixes from the highest to the lowest priority, used by the priority strategy.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "merge_strategy": {
+                    "description": "MergeStrategy settles keys defined in several files when BucketFileName selects several objects.\nOne of reject (default), first-wins, last-wins, last-updated or priority.",
+                    "type": "string",
+                    "enum": [
+                        "reject",
+                        "first-wins",
+                        "last-wins",
+                        "last-updated",
+                        "priority"
+                    ]
+                },
+                "pinned_versions": {
+                    "description": "PinnedVersions maps object names to the version that must be synchronized.\nThe synchronization fails if the live object no longer matches.",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/handlers.ObjectVersion"
+                    }
+                },
+                "preserve_manual_entries": {
+                    "description": "PreserveManualEntries keeps the entries changed through the entries API\nsince the previous synchronization instead of overriding them.",
+                    "type": "boolean"
+                },
+                "require_signature": {
+                    "description": "RequireSignature rejects source objects without a valid detached signature,\nstored in the \"signature\" metadata or in a \"\u003cobject\u003e.sig\" object.",
+                    "type": "boolean"
+                }
+            }
+        },
+        "haproxy.MapEntrie": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "description": "ExpiresAt is only known to mapSyncProxy and never sent to HAProxy.",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "value": {
+                    "type": "string"
+                }
+            }
+        },
+        "haproxy.StorageMap": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "file": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "storage_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "history.Diff": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/haproxy.MapEntrie"
+                    }
+                },
+                "deleted": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/haproxy.MapEntrie"
+                    }
+                },
+                "duplicates": {
+                    "description": "Duplicates holds the extra occurrences of live keys deleted by id.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/haproxy.MapEntrie"
+                    }
+                },
+                "updated": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/haproxy.MapEntrie"
+                    }
+                }
+            }
+        },
+        "history.Revision": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "diff": {
+                    "$ref": "#/definitions/history.Diff"
+                },
+                "map_name": {
+                    "type": "string"
+                },
+                "origin": {
+                    "description": "Origin is \"synchronize\" or \"rollback\".",
+                    "type": "string"
+                },
+                "revision": {
+                    "type": "integer"
+                },
+                "rollback_of": {
+                    "description": "RollbackOf is the revision restored by a rollback.",
+                    "type": "integer"
+                },
+                "snapshot": {
+                    "description": "Snapshot holds the map entries before the diff was applied. It is\nstored apart from the other fields, so that listing revisions does not\nread it.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/haproxy.MapEntrie"
+                    }
+                }
+            }
+        },
+        "status.SyncStatus": {
+            "type": "object",
+            "properties": {
+                "finished_at": {
+                    "type": "string"
+                },
+                "http_status": {
+                    "type": "integer"
+                },
+                "origin": {
+                    "type": "string"
+                },
+                "started_at": {
+                    "type": "string"
+                },
+                "status": {
                     "type": "string"
                 }
             }
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// New returns a logger writing to the given sinks. Events are queried from
+// the first sink implementing Querier, or from an in-memory buffer keeping
+// the last retained events.
+func New(retained int, sinks ...Sink) *Logger {
+	logger := &Logger{}
+
+	for _, sink := range sinks {
+		if querier, ok := sink.(Querier); ok && logger.querier == nil {
+			logger.querier = querier
+		}
+	}
+	if logger.querier == nil {
+		memory := newMemorySink(retained)
+		logger.querier = memory
+		sinks = append(sinks, memory)
+	}
+
+	logger.sinks = sinks
+	return logger
+}
+
+// Record writes events to every sink. Sink failures are logged and do not
+// stop the other sinks.
+func (l *Logger) Record(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	stamp(events)
+	for _, sink := range l.sinks {
+		if err := sink.Write(events); err != nil {
+			log.Error().Err(err).Msgf("%d audit event(s) could not be written to %T.", len(events), sink)
+		}
+	}
+}
+
+// Query passes the recorded events matching the filter to emit, oldest
+// first, without holding them all in memory.
+func (l *Logger) Query(filter Filter, emit func(Event) error) error {
+	return l.querier.Query(filter, emit)
+}
+
+// Close waits for the sinks to deliver their pending events, until ctx is
+// done.
+func (l *Logger) Close(ctx context.Context) error {
+	var errs []error
+	for _, sink := range l.sinks {
+		if closer, ok := sink.(Closer); ok {
+			errs = append(errs, closer.Close(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Match reports whether the event is selected by the filter.
+func (f Filter) Match(event Event) bool {
+	switch {
+	case f.MapName != "" && f.MapName != event.MapName,
+		f.Key != "" && f.Key != event.Key,
+		f.Caller != "" && f.Caller != event.Caller,
+		f.Action != "" && f.Action != event.Action,
+		f.RequestID != "" && f.RequestID != event.RequestID,
+		!f.Since.IsZero() && event.Time.Before(f.Since),
+		!f.Until.IsZero() && event.Time.After(f.Until):
+		return false
+	}
+	return true
+}
+
+func newTail(filter Filter, emit func(Event) error) *tail {
+	return &tail{filter: filter, emit: emit}
+}
+
+func (t *tail) add(event Event) error {
+	if !t.filter.Match(event) {
+		return nil
+	}
+	if t.filter.Limit <= 0 {
+		return t.emit(event)
+	}
+	if len(t.last) < t.filter.Limit {
+		t.last = append(t.last, event)
+		return nil
+	}
+	t.last[t.next] = event
+	t.next = (t.next + 1) % t.filter.Limit
+	return nil
+}
+
+// flush emits the kept events, oldest first.
+func (t *tail) flush() error {
+	for i := range t.last {
+		if err := t.emit(t.last[(t.next+i)%len(t.last)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stamp(events []Event) {
+	now := time.Now().UTC()
+	for i := range events {
+		if events[i].Time.IsZero() {
+			events[i].Time = now
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package audit
+
+const (
+	maxEventSize      = 1024 * 1024
+	webhookTimeout    = 10
+	webhookRetryCount = 3
+	// DefaultWebhookQueueSize is the number of batches of events waiting to be
+	// posted by the webhook sink.
+	DefaultWebhookQueueSize = 1000
+)
@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// newMemorySink keeps the last capacity events in memory.
+func newMemorySink(capacity int) *memorySink {
+	return &memorySink{capacity: capacity}
+}
+
+func (m *memorySink) Write(events []Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events = append(m.events, events...)
+	if m.capacity > 0 && len(m.events) > m.capacity {
+		m.events = append([]Event{}, m.events[len(m.events)-m.capacity:]...)
+	}
+	return nil
+}
+
+func (m *memorySink) Query(filter Filter, emit func(Event) error) error {
+	m.mu.RLock()
+	events := m.events
+	m.mu.RUnlock()
+
+	// Write never changes the events already recorded, so they are read
+	// without holding the lock.
+	tail := newTail(filter, emit)
+	for _, event := range events {
+		if err := tail.add(event); err != nil {
+			return err
+		}
+	}
+	return tail.flush()
+}
+
+// NewStdoutSink writes events as JSON lines to the standard output.
+func NewStdoutSink() Sink {
+	return &writerSink{file: os.Stdout}
+}
+
+func (w *writerSink) Write(events []Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return writeJSONLines(w.file, events)
+}
+
+// NewFileSink appends events as JSON lines to a file. The file is also used
+// to answer queries.
+func NewFileSink(path string) Sink {
+	return &fileSink{path: path}
+}
+
+func (f *fileSink) Write(events []Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return writeJSONLines(file, events)
+}
+
+// Query streams the file up to its size when the query starts, so that
+// writers are not blocked while the events are emitted.
+func (f *fileSink) Query(filter Filter, emit func(Event) error) error {
+	f.mu.Lock()
+	file, err := os.Open(f.path)
+	var size int64
+	if err == nil {
+		var info fs.FileInfo
+		if info, err = file.Stat(); err == nil {
+			size = info.Size()
+		} else {
+			file.Close()
+		}
+	}
+	f.mu.Unlock()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tail := newTail(filter, emit)
+	scanner := bufio.NewScanner(io.LimitReader(file, size))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxEventSize)
+	for scanner.Scan() {
+		event := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("decoding %s: %w", f.path, err)
+		}
+		if err := tail.add(event); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return tail.flush()
+}
+
+// NewWebhookSink posts events as a JSON array to an URL. The events are
+// queued and posted in the background, so that a slow or unavailable endpoint
+// does not delay the requests. Events are dropped, and Write fails, once
+// queueSize batches are waiting.
+func NewWebhookSink(url string, queueSize int) Sink {
+	w := &webhookSink{
+		url: url,
+		httpClient: resty.New().
+			SetHeader("Content-Type", "application/json").
+			SetTimeout(webhookTimeout * time.Second).
+			SetRetryCount(webhookRetryCount),
+		queue: make(chan []Event, queueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *webhookSink) Write(events []Event) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrSinkClosed
+	}
+	select {
+	case w.queue <- append([]Event{}, events...):
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting events and waits until the queued ones are posted or
+// ctx is done.
+func (w *webhookSink) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d batch(es) of audit events were not posted: %w", len(w.queue), ctx.Err())
+	}
+}
+
+func (w *webhookSink) run() {
+	defer close(w.done)
+	for events := range w.queue {
+		if err := w.post(events); err != nil {
+			log.Error().Err(err).Msgf("%d audit event(s) could not be posted to the webhook.", len(events))
+		}
+	}
+}
+
+func (w *webhookSink) post(events []Event) error {
+	resp, err := w.httpClient.R().
+		SetBody(events).
+		Post(w.url)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("Error while posting audit events: %s", resp.Status())
+	}
+	return nil
+}
+
+func writeJSONLines(file *os.File, events []Event) error {
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
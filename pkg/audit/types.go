@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// ErrQueueFull is returned when the webhook sink cannot keep up with the
+// recorded events.
+var ErrQueueFull = errors.New("the audit webhook queue is full")
+
+// ErrSinkClosed is returned when events are written to a closed sink.
+var ErrSinkClosed = errors.New("the audit sink is closed")
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+type Event struct {
+	Time       time.Time `json:"time"`
+	Caller     string    `json:"caller"`
+	RequestID  string    `json:"request_id"`
+	MapName    string    `json:"map_name"`
+	Action     string    `json:"action"`
+	Key        string    `json:"key"`
+	OldValue   string    `json:"old_value,omitempty"`
+	NewValue   string    `json:"new_value,omitempty"`
+	Source     string    `json:"source,omitempty"`
+	Generation int64     `json:"generation,omitempty"`
+}
+
+// Filter selects audit events. Zero fields match every event.
+type Filter struct {
+	MapName   string
+	Key       string
+	Caller    string
+	Action    string
+	RequestID string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// Sink receives every recorded audit event.
+type Sink interface {
+	Write(events []Event) error
+}
+
+// Querier passes the recorded audit events matching a filter to emit, oldest
+// first. It stops at the first error returned by emit.
+type Querier interface {
+	Query(filter Filter, emit func(Event) error) error
+}
+
+// Closer is implemented by the sinks holding pending events.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+type Logger struct {
+	sinks   []Sink
+	querier Querier
+}
+
+type memorySink struct {
+	capacity int
+	events   []Event
+	mu       sync.RWMutex
+}
+
+type writerSink struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+type fileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+type webhookSink struct {
+	url        string
+	httpClient *resty.Client
+	queue      chan []Event
+	done       chan struct{}
+	closed     bool
+	mu         sync.RWMutex
+}
+
+// tail passes the events selected by a filter to emit, or keeps the last
+// filter.Limit of them until flush when a limit is set.
+type tail struct {
+	filter Filter
+	emit   func(Event) error
+	last   []Event
+	next   int
+}
@@ -0,0 +1,119 @@
+package diff
+
+import (
+	"hash/fnv"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+)
+
+// New returns a Differ for the desired entries. Only the first occurrence
+// of a desired key is taken into account.
+func New(desired []haproxy.MapEntrie) *Differ {
+	return &Differ{
+		desired: desired,
+		index:   newIndex(desired),
+		seen:    make([]uint64, (len(desired)+63)/64),
+		deleted: make(map[string]bool),
+		ops:     make(map[OpType][]Op),
+	}
+}
+
+// Observe compares a live entry with the desired entries. The first
+// occurrence of a live key is compared with the desired value, and the next
+// occurrences of a kept key are reported as duplicates. Deleting a key
+// removes all its occurrences. An unchanged entry is only counted.
+func (d *Differ) Observe(live haproxy.MapEntrie) {
+	i, exists := d.index.lookup(live.Key)
+	if !exists {
+		if !d.deleted[live.Key] {
+			d.deleted[live.Key] = true
+			d.ops[Delete] = append(d.ops[Delete], Op{Type: Delete, Entrie: live, OldValue: live.Value})
+		}
+		return
+	}
+
+	if d.seen[i/64]&(1<<(i%64)) != 0 {
+		d.ops[Duplicate] = append(d.ops[Duplicate], Op{Type: Duplicate, Entrie: live, OldValue: live.Value})
+		return
+	}
+	d.seen[i/64] |= 1 << (i % 64)
+
+	if d.desired[i].Value != live.Value {
+		d.ops[Update] = append(d.ops[Update], Op{Type: Update, Entrie: d.desired[i], OldValue: live.Value})
+		return
+	}
+	d.unchanged++
+}
+
+// Changeset returns the ops of the observed entries and the creation of the
+// desired entries that were never observed.
+func (d *Differ) Changeset() *Changeset {
+	creates := []Op{}
+	for i, entrie := range d.desired {
+		// Only the first occurrence of a desired key is created.
+		if j, _ := d.index.lookup(entrie.Key); j != i {
+			continue
+		}
+		if d.seen[i/64]&(1<<(i%64)) == 0 {
+			creates = append(creates, Op{Type: Create, Entrie: entrie})
+		}
+	}
+
+	ops := make(map[OpType][]Op, len(d.ops)+1)
+	for opType, typeOps := range d.ops {
+		ops[opType] = typeOps
+	}
+	ops[Create] = creates
+	return &Changeset{ops: ops, unchanged: d.unchanged}
+}
+
+// Of returns the ops of the given type, in changeset order. Unchanged keys
+// are not kept, so there are no ops of that type.
+func (c *Changeset) Of(opType OpType) []Op {
+	if ops := c.ops[opType]; ops != nil {
+		return ops
+	}
+	return []Op{}
+}
+
+// Count returns the number of ops of the given type, or the number of
+// unchanged keys.
+func (c *Changeset) Count(opType OpType) int {
+	if opType == Unchanged {
+		return c.unchanged
+	}
+	return len(c.ops[opType])
+}
+
+func newIndex(entries []haproxy.MapEntrie) *index {
+	x := &index{
+		entries:    entries,
+		positions:  make(map[uint64]int, len(entries)),
+		collisions: make(map[string]int),
+	}
+	for i, entrie := range entries {
+		hash := hashKey(entrie.Key)
+		if j, exists := x.positions[hash]; exists {
+			if _, collided := x.collisions[entrie.Key]; entries[j].Key != entrie.Key && !collided {
+				x.collisions[entrie.Key] = i
+			}
+			continue
+		}
+		x.positions[hash] = i
+	}
+	return x
+}
+
+func (x *index) lookup(key string) (int, bool) {
+	if i, exists := x.positions[hashKey(key)]; exists && x.entries[i].Key == key {
+		return i, true
+	}
+	i, exists := x.collisions[key]
+	return i, exists
+}
+
+func hashKey(key string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return hash.Sum64()
+}
@@ -0,0 +1,204 @@
+package diff
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy/haproxytest"
+)
+
+const largeMapSize = 1_000_000
+
+func generateEntries(count int, value string) []haproxy.MapEntrie {
+	result := make([]haproxy.MapEntrie, count)
+	for i := range result {
+		result[i] = haproxy.MapEntrie{Key: fmt.Sprintf("127.0.0.1:8080/path/%d", i), Value: value}
+	}
+	return result
+}
+
+func keys(ops []Op) []string {
+	result := []string{}
+	for _, op := range ops {
+		result = append(result, op.Entrie.Key)
+	}
+	return result
+}
+
+func compute(desired, live []haproxy.MapEntrie) *Changeset {
+	differ := New(desired)
+	for _, entrie := range live {
+		differ.Observe(entrie)
+	}
+	return differ.Changeset()
+}
+
+func TestChangeset(t *testing.T) {
+	tests := []struct {
+		name       string
+		desired    []haproxy.MapEntrie
+		live       []haproxy.MapEntrie
+		create     []string
+		update     []string
+		delete     []string
+		duplicate  []string
+		unchanged  int
+		oldUpdates []string
+	}{
+		{
+			name:    "empty live map",
+			desired: haproxytest.Entries("a", "1", "b", "2"),
+			create:  []string{"a", "b"},
+		},
+		{
+			name:   "empty desired entries",
+			live:   haproxytest.Entries("a", "1", "b", "2"),
+			delete: []string{"a", "b"},
+		},
+		{
+			name:       "every kind of change",
+			desired:    haproxytest.Entries("a", "1", "b", "new", "d", "4"),
+			live:       haproxytest.Entries("c", "3", "b", "old", "a", "1"),
+			create:     []string{"d"},
+			update:     []string{"b"},
+			delete:     []string{"c"},
+			unchanged:  1,
+			oldUpdates: []string{"old"},
+		},
+		{
+			name:      "duplicated live keys",
+			desired:   haproxytest.Entries("a", "1"),
+			live:      haproxytest.Entries("a", "1", "a", "2", "b", "3", "b", "3"),
+			delete:    []string{"b"},
+			duplicate: []string{"a"},
+			unchanged: 1,
+		},
+		{
+			name:    "duplicated desired keys",
+			desired: haproxytest.Entries("a", "1", "a", "2"),
+			create:  []string{"a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changeset := compute(test.desired, test.live)
+
+			for opType, expected := range map[OpType][]string{Create: test.create, Update: test.update, Delete: test.delete, Duplicate: test.duplicate} {
+				if expected == nil {
+					expected = []string{}
+				}
+				if got := keys(changeset.Of(opType)); !reflect.DeepEqual(got, expected) {
+					t.Errorf("%s ops = %v, want %v", opType, got, expected)
+				}
+				if got := changeset.Count(opType); got != len(expected) {
+					t.Errorf("%s count = %d, want %d", opType, got, len(expected))
+				}
+			}
+			if got := changeset.Count(Unchanged); got != test.unchanged {
+				t.Errorf("unchanged count = %d, want %d", got, test.unchanged)
+			}
+			if got := changeset.Of(Unchanged); len(got) != 0 {
+				t.Errorf("unchanged ops = %v, want none", got)
+			}
+			for i, op := range changeset.Of(Update) {
+				if op.OldValue != test.oldUpdates[i] {
+					t.Errorf("old value of %s = %q, want %q", op.Entrie.Key, op.OldValue, test.oldUpdates[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChangesetLargeMap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large map test skipped in short mode")
+	}
+
+	desired := generateEntries(largeMapSize, "10")
+	live := generateEntries(largeMapSize, "10")
+	// Update the first 1000 keys, delete the last 1000 live keys and create
+	// 1000 new ones.
+	for i := 0; i < 1000; i++ {
+		live[i].Value = "5"
+		live[largeMapSize-1-i].Key = fmt.Sprintf("127.0.0.1:8080/removed/%d", i)
+	}
+
+	changeset := compute(desired, live)
+
+	for opType, expected := range map[OpType]int{Create: 1000, Update: 1000, Delete: 1000, Duplicate: 0, Unchanged: largeMapSize - 2000} {
+		if got := changeset.Count(opType); got != expected {
+			t.Errorf("%s count = %d, want %d", opType, got, expected)
+		}
+	}
+}
+
+func TestObserveUnchangedEntriesDoesNotGrow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large map test skipped in short mode")
+	}
+
+	desired := generateEntries(largeMapSize, "10")
+	differ := New(desired)
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	for _, entrie := range desired {
+		differ.Observe(entrie)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	if growth := int64(after.HeapAlloc) - int64(before.HeapAlloc); growth > 1<<20 {
+		t.Errorf("observing %d unchanged entries grew the heap by %d bytes", largeMapSize, growth)
+	}
+	if got := differ.Changeset().Count(Unchanged); got != largeMapSize {
+		t.Errorf("unchanged count = %d, want %d", got, largeMapSize)
+	}
+	runtime.KeepAlive(differ)
+}
+
+func BenchmarkNew(b *testing.B) {
+	desired := generateEntries(largeMapSize, "10")
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		New(desired)
+	}
+}
+
+func BenchmarkObserve(b *testing.B) {
+	desired := generateEntries(largeMapSize, "10")
+	live := generateEntries(largeMapSize, "5")
+	for i := 0; i < len(live); i += 2 {
+		live[i].Value = "10"
+	}
+	var differ *Differ
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		// A differ holds a single pass over the live map.
+		if i%largeMapSize == 0 {
+			b.StopTimer()
+			differ = New(desired)
+			b.StartTimer()
+		}
+		differ.Observe(live[i%largeMapSize])
+	}
+}
+
+func BenchmarkChangeset(b *testing.B) {
+	desired := generateEntries(largeMapSize, "10")
+	live := generateEntries(largeMapSize, "5")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		compute(desired, live)
+	}
+}
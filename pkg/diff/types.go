@@ -0,0 +1,51 @@
+package diff
+
+import "github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+
+// OpType is the change needed on a key to reach the desired entries.
+type OpType string
+
+const (
+	Create    OpType = "create"
+	Update    OpType = "update"
+	Delete    OpType = "delete"
+	Unchanged OpType = "unchanged"
+	// Duplicate is an extra live entry of a kept key, to delete by id.
+	Duplicate OpType = "duplicate"
+)
+
+// Op is the result of the diff for a single key. OldValue holds the live
+// value of updated and deleted keys.
+type Op struct {
+	Type     OpType
+	Entrie   haproxy.MapEntrie
+	OldValue string
+}
+
+// Changeset lists the ops turning the live entries into the desired ones,
+// by type. The ops of the live keys are in live order and the keys to create
+// in desired order. Unchanged keys are only counted, so that a changeset
+// grows with the changes rather than with the live map.
+type Changeset struct {
+	ops       map[OpType][]Op
+	unchanged int
+}
+
+// Differ compares the live entries, observed one by one, with the desired
+// entries.
+type Differ struct {
+	desired   []haproxy.MapEntrie
+	index     *index
+	seen      []uint64
+	deleted   map[string]bool
+	ops       map[OpType][]Op
+	unchanged int
+}
+
+// index maps the 64-bit hash of each key to its position in the desired
+// entries. The rare keys colliding with another key are kept aside.
+type index struct {
+	entries    []haproxy.MapEntrie
+	positions  map[uint64]int
+	collisions map[string]int
+}
@@ -0,0 +1,157 @@
+package expiry
+
+import (
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/rs/zerolog/log"
+)
+
+const stateName = "expirations"
+
+func NewSchedule() *Schedule {
+	return &Schedule{expirations: make(map[string]map[string]Expiration)}
+}
+
+// Persist loads the expirations saved in store, then saves them there on
+// every change.
+func (s *Schedule) Persist(store StateStore) error {
+	expirations := []Expiration{}
+	if err := store.LoadState(stateName, &expirations); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, expiration := range expirations {
+		if _, exists := s.expirations[expiration.MapName]; !exists {
+			s.expirations[expiration.MapName] = make(map[string]Expiration)
+		}
+		s.expirations[expiration.MapName][expiration.Key] = expiration
+	}
+	s.store = store
+	return nil
+}
+
+// Replace schedules the expiration of the entries of a map carrying an
+// expiry date, and forgets the previous schedule of that map.
+func (s *Schedule) Replace(mapName string, entries []haproxy.MapEntrie) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.expirations[mapName]) == 0 && !hasExpiry(entries) {
+		return
+	}
+	delete(s.expirations, mapName)
+	s.add(mapName, entries)
+	s.save()
+}
+
+// Add schedules the expiration of the entries of a map carrying an expiry
+// date, and keeps the schedule of the other entries of that map.
+func (s *Schedule) Add(mapName string, entries []haproxy.MapEntrie) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !hasExpiry(entries) {
+		return
+	}
+	s.add(mapName, entries)
+	s.save()
+}
+
+func (s *Schedule) add(mapName string, entries []haproxy.MapEntrie) {
+	for _, entrie := range entries {
+		if entrie.ExpiresAt == nil {
+			continue
+		}
+		if _, exists := s.expirations[mapName]; !exists {
+			s.expirations[mapName] = make(map[string]Expiration)
+		}
+		s.expirations[mapName][entrie.Key] = Expiration{
+			MapName:   mapName,
+			Key:       entrie.Key,
+			Value:     entrie.Value,
+			ExpiresAt: *entrie.ExpiresAt,
+		}
+	}
+}
+
+// Expired returns the expirations of every map reached at the given time.
+func (s *Schedule) Expired(now time.Time) []Expiration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expired := []Expiration{}
+	for _, expirations := range s.expirations {
+		for _, expiration := range expirations {
+			if !expiration.ExpiresAt.After(now) {
+				expired = append(expired, expiration)
+			}
+		}
+	}
+	return expired
+}
+
+// Remove forgets the expiration of a key.
+func (s *Schedule) Remove(mapName, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.expirations[mapName][key]; !exists {
+		return
+	}
+	delete(s.expirations[mapName], key)
+	if len(s.expirations[mapName]) == 0 {
+		delete(s.expirations, mapName)
+	}
+	s.save()
+}
+
+// Pending returns the number of expirations scheduled for a map.
+func (s *Schedule) Pending(mapName string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.expirations[mapName])
+}
+
+// save persists every expiration when a store is set. It is called with the
+// lock held, so that the saves happen in the order of the changes.
+func (s *Schedule) save() {
+	if s.store == nil {
+		return
+	}
+
+	expirations := []Expiration{}
+	for _, mapExpirations := range s.expirations {
+		for _, expiration := range mapExpirations {
+			expirations = append(expirations, expiration)
+		}
+	}
+	if err := s.store.SaveState(stateName, expirations); err != nil {
+		log.Warn().Err(err).Msg("The expirations could not be saved.")
+	}
+}
+
+func hasExpiry(entries []haproxy.MapEntrie) bool {
+	for _, entrie := range entries {
+		if entrie.ExpiresAt != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Active drops the entries expired at the given time, so that they are
+// treated as absent.
+func Active(entries []haproxy.MapEntrie, now time.Time) []haproxy.MapEntrie {
+	active := entries[:0:0]
+	for _, entrie := range entries {
+		if entrie.ExpiresAt == nil || entrie.ExpiresAt.After(now) {
+			active = append(active, entrie)
+		}
+	}
+	return active
+}
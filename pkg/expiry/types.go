@@ -0,0 +1,26 @@
+package expiry
+
+import (
+	"sync"
+	"time"
+)
+
+// Expiration is a synchronized map entry to remove once ExpiresAt is reached.
+type Expiration struct {
+	MapName   string    `json:"map_name"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Schedule struct {
+	expirations map[string]map[string]Expiration
+	store       StateStore
+	mu          sync.RWMutex
+}
+
+// StateStore persists the schedule, so that it survives a restart.
+type StateStore interface {
+	LoadState(name string, v interface{}) error
+	SaveState(name string, v interface{}) error
+}
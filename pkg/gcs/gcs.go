@@ -2,9 +2,14 @@ package gcs
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 
 	"cloud.google.com/go/storage"
+	"github.com/matthisholleville/mapsyncproxy/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 )
 
@@ -18,10 +23,14 @@ func NewClient() *GCSClientWrapper {
 	return &GCSClientWrapper{client}
 }
 
-func (c *GCSClientWrapper) ListFiles(bucket string) (*[]storage.ObjectAttrs, error) {
-	ctx := context.Background()
+// ListFiles lists the objects of a bucket whose name starts with prefix.
+// An empty prefix lists the whole bucket.
+func (c *GCSClientWrapper) ListFiles(ctx context.Context, bucket, prefix string) (_ *[]storage.ObjectAttrs, err error) {
+	ctx, span := tracing.Start(ctx, "gcs.list", attribute.String("gcs.bucket", bucket), attribute.String("gcs.prefix", prefix))
+	defer func() { tracing.End(span, err) }()
+
 	files := []storage.ObjectAttrs{}
-	items := c.Client.Bucket(bucket).Objects(ctx, nil)
+	items := c.Client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
 	for {
 		attrs, err := items.Next()
 		if err == iterator.Done {
@@ -32,20 +41,62 @@ func (c *GCSClientWrapper) ListFiles(bucket string) (*[]storage.ObjectAttrs, err
 		}
 		files = append(files, *attrs)
 	}
+	span.SetAttributes(attribute.Int("gcs.objects", len(files)))
 	return &files, nil
 }
 
-// downloadFile downloads an object to a file.
-func (c *GCSClientWrapper) DownloadFile(bucket, object string) (*storage.Reader, error) {
-
-	ctx := context.Background()
+// DownloadFile opens a reader on the stored content of an object, without
+// decompressive transcoding. The caller must close it.
+// When generation is not zero, the read fails with ErrVersionMismatch unless
+// the live object still has that generation.
+func (c *GCSClientWrapper) DownloadFile(ctx context.Context, bucket, object string, generation int64) (*Object, error) {
+	handle := c.Bucket(bucket).Object(object).ReadCompressed(true)
+	if generation != 0 {
+		handle = handle.If(storage.Conditions{GenerationMatch: generation})
+	}
 
-	rc, err := c.Bucket(bucket).Object(object).NewReader(ctx)
+	rc, err := handle.NewReader(ctx)
 	if err != nil {
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+			return nil, fmt.Errorf("Object(%q).NewReader: generation %d: %w", object, generation, ErrVersionMismatch)
+		}
 		return nil, fmt.Errorf("Object(%q).NewReader: %w", object, err)
 	}
-	defer rc.Close()
 
-	return rc, nil
+	return &Object{ReadCloser: rc, Attrs: rc.Attrs}, nil
+}
+
+// GetMetadata returns the custom metadata of an object generation.
+func (c *GCSClientWrapper) GetMetadata(ctx context.Context, bucket, object string, generation int64) (map[string]string, error) {
+	attrs, err := c.Bucket(bucket).Object(object).Generation(generation).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Object(%q).Attrs: %w", object, err)
+	}
+
+	return attrs.Metadata, nil
+}
+
+// UploadFile writes data to an object, replacing any previous content.
+func (c *GCSClientWrapper) UploadFile(ctx context.Context, bucket, object, contentType string, data []byte) error {
+	wc := c.Bucket(bucket).Object(object).NewWriter(ctx)
+	wc.ContentType = contentType
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return fmt.Errorf("Object(%q).NewWriter: %w", object, err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("Writer.Close: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteFile deletes an object.
+func (c *GCSClientWrapper) DeleteFile(ctx context.Context, bucket, object string) error {
+	if err := c.Bucket(bucket).Object(object).Delete(ctx); err != nil {
+		return fmt.Errorf("Object(%q).Delete: %w", object, err)
+	}
 
+	return nil
 }
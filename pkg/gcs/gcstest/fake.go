@@ -0,0 +1,149 @@
+// Package gcstest provides an in-memory bucket storage to test the code using
+// a gcs.Storage without Google Cloud Storage.
+package gcstest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/matthisholleville/mapsyncproxy/pkg/gcs"
+)
+
+func NewFakeStorage() *FakeStorage {
+	return &FakeStorage{
+		objects: make(map[string]*fakeObject),
+		errors:  make(map[string]error),
+	}
+}
+
+// FailOn makes an operation, named after its method (e.g. "DownloadFile"),
+// return the given error. A nil error restores the operation.
+func (f *FakeStorage) FailOn(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.errors, operation)
+		return
+	}
+	f.errors[operation] = err
+}
+
+// PutObject stores a new generation of the object named attrs.Name, keeping
+// its content type, content encoding and metadata, and returns its attributes.
+func (f *FakeStorage) PutObject(bucket string, attrs storage.ObjectAttrs, data []byte) storage.ObjectAttrs {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.put(bucket, attrs, data)
+}
+
+func (f *FakeStorage) ListFiles(ctx context.Context, bucket, prefix string) (*[]storage.ObjectAttrs, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	files := []storage.ObjectAttrs{}
+	if err := f.operation(ctx, "ListFiles"); err != nil {
+		return &files, err
+	}
+	for _, object := range f.objects {
+		if object.attrs.Bucket == bucket && strings.HasPrefix(object.attrs.Name, prefix) {
+			files = append(files, object.attrs)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return &files, nil
+}
+
+// DownloadFile returns storage.ErrObjectNotExist or gcs.ErrVersionMismatch
+// like the Cloud Storage client.
+func (f *FakeStorage) DownloadFile(ctx context.Context, bucket, object string, generation int64) (*gcs.Object, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "DownloadFile"); err != nil {
+		return nil, err
+	}
+	stored, exists := f.objects[bucket+"/"+object]
+	if !exists {
+		return nil, fmt.Errorf("Object(%q).NewReader: %w", object, storage.ErrObjectNotExist)
+	}
+	if generation != 0 && generation != stored.attrs.Generation {
+		return nil, fmt.Errorf("Object(%q).NewReader: generation %d: %w", object, generation, gcs.ErrVersionMismatch)
+	}
+	return &gcs.Object{
+		ReadCloser: io.NopCloser(bytes.NewReader(stored.data)),
+		Attrs: storage.ReaderObjectAttrs{
+			Size:            stored.attrs.Size,
+			ContentType:     stored.attrs.ContentType,
+			ContentEncoding: stored.attrs.ContentEncoding,
+			Generation:      stored.attrs.Generation,
+			LastModified:    stored.attrs.Updated,
+		},
+	}, nil
+}
+
+// GetMetadata only knows the live generation of an object.
+func (f *FakeStorage) GetMetadata(ctx context.Context, bucket, object string, generation int64) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "GetMetadata"); err != nil {
+		return nil, err
+	}
+	stored, exists := f.objects[bucket+"/"+object]
+	if !exists || (generation != 0 && generation != stored.attrs.Generation) {
+		return nil, fmt.Errorf("Object(%q).Attrs: %w", object, storage.ErrObjectNotExist)
+	}
+	return stored.attrs.Metadata, nil
+}
+
+func (f *FakeStorage) UploadFile(ctx context.Context, bucket, object, contentType string, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "UploadFile"); err != nil {
+		return err
+	}
+	f.put(bucket, storage.ObjectAttrs{Name: object, ContentType: contentType}, data)
+	return nil
+}
+
+func (f *FakeStorage) DeleteFile(ctx context.Context, bucket, object string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "DeleteFile"); err != nil {
+		return err
+	}
+	if _, exists := f.objects[bucket+"/"+object]; !exists {
+		return fmt.Errorf("Object(%q).Delete: %w", object, storage.ErrObjectNotExist)
+	}
+	delete(f.objects, bucket+"/"+object)
+	return nil
+}
+
+// operation returns the configured failure of an operation, or the error of
+// a done ctx. It is called with the lock held.
+func (f *FakeStorage) operation(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.errors[name]
+}
+
+func (f *FakeStorage) put(bucket string, attrs storage.ObjectAttrs, data []byte) storage.ObjectAttrs {
+	f.nextGeneration++
+	attrs.Bucket = bucket
+	attrs.Generation = f.nextGeneration
+	attrs.Size = int64(len(data))
+	attrs.Updated = time.Now()
+	f.objects[bucket+"/"+attrs.Name] = &fakeObject{attrs: attrs, data: append([]byte{}, data...)}
+	return attrs
+}
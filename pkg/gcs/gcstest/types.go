@@ -0,0 +1,21 @@
+package gcstest
+
+import (
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+// FakeStorage is an in-memory gcs.Storage. Each upload creates a new
+// generation of the object, and operations can be made to fail.
+type FakeStorage struct {
+	objects        map[string]*fakeObject
+	errors         map[string]error
+	nextGeneration int64
+	mu             sync.Mutex
+}
+
+type fakeObject struct {
+	attrs storage.ObjectAttrs
+	data  []byte
+}
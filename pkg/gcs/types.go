@@ -1,6 +1,31 @@
 package gcs
 
-import "cloud.google.com/go/storage"
+import (
+	"context"
+	"errors"
+	"io"
+
+	"cloud.google.com/go/storage"
+)
+
+// ErrVersionMismatch is returned when an object no longer matches the pinned version.
+var ErrVersionMismatch = errors.New("object does not match the pinned version")
+
+// Storage reads and writes the objects of a bucket. It is implemented by
+// GCSClientWrapper and by the in-memory gcstest.FakeStorage.
+type Storage interface {
+	ListFiles(ctx context.Context, bucket, prefix string) (*[]storage.ObjectAttrs, error)
+	DownloadFile(ctx context.Context, bucket, object string, generation int64) (*Object, error)
+	GetMetadata(ctx context.Context, bucket, object string, generation int64) (map[string]string, error)
+	UploadFile(ctx context.Context, bucket, object, contentType string, data []byte) error
+	DeleteFile(ctx context.Context, bucket, object string) error
+}
+
+// Object is the stored content of an object generation being read.
+type Object struct {
+	io.ReadCloser
+	Attrs storage.ReaderObjectAttrs
+}
 
 type GCSClientWrapper struct {
 	*storage.Client
@@ -0,0 +1,180 @@
+package haproxy_test
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy/haproxytest"
+)
+
+// The fixture files of testdata/dataplane/<version> are responses recorded
+// from a Dataplane API of that version.
+
+func TestDetectAPIVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixtures map[string]haproxytest.Fixture
+		version  string
+		requests []string
+	}{
+		{
+			name: "v3",
+			fixtures: map[string]haproxytest.Fixture{
+				"GET /v3/info": {Status: http.StatusOK, File: "info.json"},
+				"GET /v2/info": {Status: http.StatusOK, File: "info.json"},
+			},
+			version:  haproxy.APIVersion3,
+			requests: []string{"GET /v3/info"},
+		},
+		{
+			name:     "fallback to v2",
+			fixtures: map[string]haproxytest.Fixture{"GET /v2/info": {Status: http.StatusOK, File: "info.json"}},
+			version:  haproxy.APIVersion2,
+			requests: []string{"GET /v3/info", "GET /v2/info"},
+		},
+		{
+			name:     "no version answering",
+			fixtures: map[string]haproxytest.Fixture{},
+			version:  haproxy.APIVersion2,
+			requests: []string{"GET /v3/info", "GET /v2/info"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dataplane := haproxytest.NewDataplane(t, filepath.Join("testdata", "dataplane", test.version), test.fixtures)
+
+			client, err := haproxy.NewClient(haproxy.StaticCredentials("admin", "secret"), dataplane.Host(), nil, haproxy.APIVersionAuto)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dataplane.RequestLines(); !reflect.DeepEqual(got, test.requests) {
+				t.Errorf("requests = %v, want %v", got, test.requests)
+			}
+
+			// The next requests use the routes of the detected version.
+			client.GetMapEntries(context.Background(), "rate-limits")
+			requests := dataplane.RequestLines()
+			if got := requests[len(requests)-1]; !strings.HasPrefix(got, "GET /"+test.version+"/services/") {
+				t.Errorf("request after the detection = %s, want a %s route", got, test.version)
+			}
+		})
+	}
+}
+
+func TestClientRoutes(t *testing.T) {
+	tests := []struct {
+		version  string
+		fixtures map[string]haproxytest.Fixture
+	}{
+		{
+			version: haproxy.APIVersion2,
+			fixtures: map[string]haproxytest.Fixture{
+				"GET /v2/services/haproxy/runtime/maps":                                                    {Status: http.StatusOK, File: "maps.json"},
+				"GET /v2/services/haproxy/runtime/maps/rate-limits":                                        {Status: http.StatusOK, File: "map.json"},
+				"GET /v2/services/haproxy/runtime/maps/missing":                                            {Status: http.StatusNotFound, File: "not_found.json"},
+				"PUT /v2/services/haproxy/runtime/maps/rate-limits?force_sync=true":                        {Status: http.StatusCreated, File: "map_entries.json"},
+				"GET /v2/services/haproxy/runtime/maps_entries?map=rate-limits":                            {Status: http.StatusOK, File: "map_entries.json"},
+				"GET /v2/services/haproxy/runtime/maps_entries/%2Fapi?map=rate-limits":                     {Status: http.StatusOK, File: "map_entry.json"},
+				"GET /v2/services/haproxy/runtime/maps_entries/%2Fmissing?map=rate-limits":                 {Status: http.StatusNotFound, File: "not_found.json"},
+				"POST /v2/services/haproxy/runtime/maps_entries?map=rate-limits&force_sync=true":           {Status: http.StatusCreated, File: "map_entry.json"},
+				"PUT /v2/services/haproxy/runtime/maps_entries/%2Fapi?map=rate-limits&force_sync=true":     {Status: http.StatusOK, File: "map_entry.json"},
+				"DELETE /v2/services/haproxy/runtime/maps_entries/%2Fapi?map=rate-limits&force_sync=true":  {Status: http.StatusNoContent},
+				"DELETE /v2/services/haproxy/runtime/maps_entries/%230x1?map=rate-limits&force_sync=true":  {Status: http.StatusNoContent},
+				"DELETE /v2/services/haproxy/runtime/maps/rate-limits?forceSync=true":                      {Status: http.StatusNoContent},
+				"POST /v2/services/haproxy/runtime/maps_entries?map=conflicts&force_sync=true":             {Status: http.StatusConflict, File: "conflict.json"},
+				"PUT /v2/services/haproxy/runtime/maps_entries/%2Fmissing?map=rate-limits&force_sync=true": {Status: http.StatusNotFound, File: "not_found.json"},
+			},
+		},
+		{
+			version: haproxy.APIVersion3,
+			fixtures: map[string]haproxytest.Fixture{
+				"GET /v3/services/haproxy/runtime/maps":                                                {Status: http.StatusOK, File: "maps.json"},
+				"GET /v3/services/haproxy/runtime/maps/rate-limits":                                    {Status: http.StatusOK, File: "map.json"},
+				"GET /v3/services/haproxy/runtime/maps/missing":                                        {Status: http.StatusNotFound, File: "not_found.json"},
+				"PUT /v3/services/haproxy/runtime/maps/rate-limits?force_sync=true":                    {Status: http.StatusCreated, File: "map_entries.json"},
+				"GET /v3/services/haproxy/runtime/maps/rate-limits/entries":                            {Status: http.StatusOK, File: "map_entries.json"},
+				"GET /v3/services/haproxy/runtime/maps/rate-limits/entries/%2Fapi":                     {Status: http.StatusOK, File: "map_entry.json"},
+				"GET /v3/services/haproxy/runtime/maps/rate-limits/entries/%2Fmissing":                 {Status: http.StatusNotFound, File: "not_found.json"},
+				"POST /v3/services/haproxy/runtime/maps/rate-limits/entries?force_sync=true":           {Status: http.StatusCreated, File: "map_entry.json"},
+				"PUT /v3/services/haproxy/runtime/maps/rate-limits/entries/%2Fapi?force_sync=true":     {Status: http.StatusOK, File: "map_entry.json"},
+				"DELETE /v3/services/haproxy/runtime/maps/rate-limits/entries/%2Fapi?force_sync=true":  {Status: http.StatusNoContent},
+				"DELETE /v3/services/haproxy/runtime/maps/rate-limits/entries/%230x1?force_sync=true":  {Status: http.StatusNoContent},
+				"DELETE /v3/services/haproxy/runtime/maps/rate-limits?force_sync=true":                 {Status: http.StatusNoContent},
+				"POST /v3/services/haproxy/runtime/maps/conflicts/entries?force_sync=true":             {Status: http.StatusConflict, File: "conflict.json"},
+				"PUT /v3/services/haproxy/runtime/maps/rate-limits/entries/%2Fmissing?force_sync=true": {Status: http.StatusNotFound, File: "not_found.json"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			dataplane := haproxytest.NewDataplane(t, filepath.Join("testdata", "dataplane", test.version), test.fixtures)
+			client, err := haproxy.NewClient(haproxy.StaticCredentials("admin", "secret"), dataplane.Host(), nil, test.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+
+			maps, err := client.ListMaps(ctx)
+			if err != nil || len(*maps) != 1 || (*maps)[0].Name() != "rate-limits" {
+				t.Errorf("ListMaps = %v, %v, want the rate-limits map", *maps, err)
+			}
+			runtimeMap, err := client.GetMap(ctx, "rate-limits")
+			if err != nil || runtimeMap.EntryCount() != 2 {
+				t.Errorf("GetMap = %v, %v, want the rate-limits map with 2 entries", runtimeMap, err)
+			}
+			if _, err := client.GetMap(ctx, "missing"); err != haproxy.ErrMapNotFound {
+				t.Errorf("GetMap of a missing map = %v, want haproxy.ErrMapNotFound", err)
+			}
+
+			entries, err := client.GetMapEntries(ctx, "rate-limits")
+			expected := []haproxy.MapEntrie{{Id: "0x55d0c9b46d70", Key: "/api", Value: "10"}, {Id: "0x55d0c9b46e10", Key: "/login", Value: "5"}}
+			if err != nil || !reflect.DeepEqual(*entries, expected) {
+				t.Errorf("GetMapEntries = %v, %v, want %v", *entries, err, expected)
+			}
+			entrie, err := client.GetMapEntrie(ctx, "/api", "rate-limits")
+			if err != nil || entrie.Value != "10" {
+				t.Errorf("GetMapEntrie = %v, %v, want /api = 10", entrie, err)
+			}
+			if _, err := client.GetMapEntrie(ctx, "/missing", "rate-limits"); err != haproxy.ErrMapEntrieNotFound {
+				t.Errorf("GetMapEntrie of a missing key = %v, want haproxy.ErrMapEntrieNotFound", err)
+			}
+			if _, err := client.CreateMapEntrie(ctx, &haproxy.MapEntrie{Key: "/api", Value: "10"}, "rate-limits"); err != nil {
+				t.Errorf("CreateMapEntrie: %v", err)
+			}
+			if _, err := client.CreateMapEntrie(ctx, &haproxy.MapEntrie{Key: "/api", Value: "10"}, "conflicts"); err != haproxy.ErrMapEntrieAlreadyExists {
+				t.Errorf("CreateMapEntrie of an existing key = %v, want haproxy.ErrMapEntrieAlreadyExists", err)
+			}
+			if _, err := client.UpdateMapEntrie(ctx, &haproxy.MapEntrie{Key: "/api", Value: "20"}, "rate-limits"); err != nil {
+				t.Errorf("UpdateMapEntrie: %v", err)
+			}
+			if _, err := client.UpdateMapEntrie(ctx, &haproxy.MapEntrie{Key: "/missing", Value: "20"}, "rate-limits"); err != haproxy.ErrMapEntrieNotFound {
+				t.Errorf("UpdateMapEntrie of a missing key = %v, want haproxy.ErrMapEntrieNotFound", err)
+			}
+			if _, err := client.DeleteMapEntrie(ctx, &haproxy.MapEntrie{Key: "/api"}, "rate-limits"); err != nil {
+				t.Errorf("DeleteMapEntrie: %v", err)
+			}
+			if err := client.DeleteMapEntrieById(ctx, "0x1", "rate-limits"); err != nil {
+				t.Errorf("DeleteMapEntrieById: %v", err)
+			}
+			if err := client.ClearMap(ctx, "rate-limits"); err != nil {
+				t.Errorf("ClearMap: %v", err)
+			}
+			if err := client.ReplaceMapEntries(ctx, "rate-limits", expected); err != nil {
+				t.Errorf("ReplaceMapEntries: %v", err)
+			}
+
+			for _, request := range dataplane.RequestLines() {
+				if _, exists := test.fixtures[request]; !exists {
+					t.Errorf("unexpected request %s", request)
+				}
+			}
+		})
+	}
+}
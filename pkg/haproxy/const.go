@@ -1,6 +1,17 @@
 package haproxy
 
 const (
-	retryCount    = 3
-	retryWaitTime = 2
+	retryCount = 3
+	// retryWaitTime and retryMaxWaitTime bound the exponential backoff
+	// between retries, in milliseconds.
+	retryWaitTime    = 500
+	retryMaxWaitTime = 10000
+	// maxErrorBodySize bounds the error responses read from the Dataplane API.
+	maxErrorBodySize = 64 * 1024
+
+	// runtimeAPITimeout bounds each read and write on the Runtime API socket, in seconds.
+	runtimeAPITimeout = 10
+	// maxCommandLength keeps the batched Runtime API commands below the
+	// default HAProxy buffer size.
+	maxCommandLength = 8192
 )
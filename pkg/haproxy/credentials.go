@@ -0,0 +1,68 @@
+package haproxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// StaticCredentials returns fixed credentials for every host.
+func StaticCredentials(username, password string) Credentials {
+	return func(string) (string, string, error) {
+		return username, password, nil
+	}
+}
+
+// FileCredentials reads the username and the password from two files, such
+// as a mounted Kubernetes secret, for every host. The files are read again
+// when they change.
+func FileCredentials(usernameFile, passwordFile string) Credentials {
+	username := &secretFile{path: usernameFile}
+	password := &secretFile{path: passwordFile}
+	return func(string) (string, string, error) {
+		usernameContent, err := username.read()
+		if err != nil {
+			return "", "", err
+		}
+		passwordContent, err := password.read()
+		if err != nil {
+			return "", "", err
+		}
+		return strings.TrimSpace(usernameContent), strings.TrimSpace(passwordContent), nil
+	}
+}
+
+// CheckCredentials returns an error when the credentials of host cannot be
+// read or are empty.
+func CheckCredentials(credentials Credentials, host string) error {
+	username, password, err := credentials(host)
+	if err != nil {
+		return err
+	}
+	if username == "" || password == "" {
+		return fmt.Errorf("the Dataplane API username and password of %s must not be empty", host)
+	}
+	return nil
+}
+
+// read returns the content of the file, read again only when its
+// modification time changed.
+func (f *secretFile) read() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	modTime, err := modificationTime(f.path)
+	if err != nil {
+		return "", err
+	}
+	if f.loaded && modTime.Equal(f.modTime) {
+		return f.content, nil
+	}
+
+	content, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+	f.content, f.modTime, f.loaded = string(content), modTime, true
+	return f.content, nil
+}
@@ -0,0 +1,78 @@
+package haproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newAuthServer starts a Dataplane API answering the runtime maps listing,
+// and returns the basic auth credentials of the requests it received.
+func newAuthServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	received := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		received = append(received, username+":"+password)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &received
+}
+
+func TestCredentialsRotation(t *testing.T) {
+	server, received := newAuthServer(t)
+	dir := t.TempDir()
+	usernameFile, passwordFile := filepath.Join(dir, "username"), filepath.Join(dir, "password")
+	writeFile(t, usernameFile, []byte("admin\n"))
+	writeFile(t, passwordFile, []byte("secret\n"))
+
+	client, err := NewClient(FileCredentials(usernameFile, passwordFile), strings.TrimPrefix(server.URL, "http://"), nil, APIVersion2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ListMaps(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated credentials are used by the next request.
+	writeFile(t, passwordFile, []byte("rotated\n"))
+	if _, err := client.ListMaps(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"admin:secret", "admin:rotated"}; !reflect.DeepEqual(*received, expected) {
+		t.Errorf("requests authenticated with %v, want %v", *received, expected)
+	}
+}
+
+func TestFileCredentials(t *testing.T) {
+	dir := t.TempDir()
+	usernameFile, passwordFile := filepath.Join(dir, "username"), filepath.Join(dir, "password")
+	writeFile(t, usernameFile, []byte("admin\n"))
+	writeFile(t, passwordFile, []byte(""))
+	credentials := FileCredentials(usernameFile, passwordFile)
+
+	if err := CheckCredentials(credentials, "127.0.0.1:5555"); err == nil {
+		t.Error("CheckCredentials succeeded with an empty password")
+	}
+
+	writeFile(t, passwordFile, []byte("secret\n"))
+	if err := CheckCredentials(credentials, "127.0.0.1:5555"); err != nil {
+		t.Errorf("CheckCredentials after the password was written: %v", err)
+	}
+
+	if err := os.Remove(usernameFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckCredentials(credentials, "127.0.0.1:5555"); err == nil {
+		t.Error("CheckCredentials succeeded without the username file")
+	}
+}
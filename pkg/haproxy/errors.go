@@ -0,0 +1,96 @@
+package haproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Error while %s: %s", e.Operation, e.Status)
+	}
+	return fmt.Sprintf("Error while %s: %s: %s", e.Operation, e.Status, e.Message)
+}
+
+// Is matches the error kind of the status code.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrTransient:
+		return isTransientStatus(e.StatusCode)
+	default:
+		return false
+	}
+}
+
+// newAPIError decodes the error response of an operation.
+func newAPIError(operation string, resp *resty.Response) error {
+	return decodeAPIError(operation, resp.StatusCode(), resp.Status(), resp.Body())
+}
+
+func decodeAPIError(operation string, statusCode int, status string, body []byte) error {
+	apiError := &APIError{
+		Operation:  operation,
+		StatusCode: statusCode,
+		Status:     status,
+	}
+
+	response := errorResponse{}
+	if err := json.Unmarshal(body, &response); err == nil {
+		apiError.Code = response.Code
+		apiError.Message = strings.TrimSpace(response.Message)
+	} else if len(body) <= maxErrorBodySize {
+		apiError.Message = strings.TrimSpace(string(body))
+	}
+	return apiError
+}
+
+// isRetryable retries the idempotent requests failing with a network error
+// or a transient status. Creations are never retried, since a lost response
+// would create the entry twice.
+func isRetryable(resp *resty.Response, err error) bool {
+	if resp == nil || resp.Request == nil {
+		return false
+	}
+	switch resp.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return isTransientStatus(resp.StatusCode())
+}
+
+func isTransientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryAfter honors the Retry-After header in seconds. Without it, resty
+// waits for an exponential backoff with jitter.
+func retryAfter(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(resp.Header().Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, nil
+}
@@ -1,33 +1,130 @@
 package haproxy
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/matthisholleville/mapsyncproxy/pkg/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// NewClient returns a Dataplane API client. The connection uses plain HTTP
+// when tlsConfig is nil, HTTPS otherwise. With APIVersionAuto, the API
+// version is detected at once, falling back to v2 when the Dataplane API
+// cannot be reached.
 func NewClient(
-	username string,
-	password string,
+	credentials Credentials,
 	serverIP string,
-	insecure bool,
-) *Client {
-	scheme := "https"
-	if insecure {
-		scheme = "http"
+	tlsConfig *TLSConfig,
+	apiVersion string,
+) (*Client, error) {
+	scheme := "http"
+	httpClient := resty.New()
+	if tlsConfig != nil {
+		scheme = "https"
+		config, err := newTLSConfig(*tlsConfig, serverIP)
+		if err != nil {
+			return nil, err
+		}
+		httpClient.SetTLSClientConfig(config)
 	}
 
-	return &Client{
-		username: username,
-		password: password,
-		HTTPClient: resty.New().
-			SetBaseURL(fmt.Sprintf("%s://%s/v2", scheme, serverIP)).
+	c := &Client{
+		credentials: credentials,
+		HTTPClient: httpClient.
 			SetHeader("Content-Type", "application/json").
 			SetHeader("Accept", "application/json; charset=utf-8").
-			SetBasicAuth(username, password).
 			SetRetryCount(retryCount).
-			SetRetryWaitTime(retryWaitTime * time.Second),
-		serverIP: fmt.Sprintf("%s://%s/v2", scheme, serverIP),
+			SetRetryWaitTime(retryWaitTime * time.Millisecond).
+			SetRetryMaxWaitTime(retryMaxWaitTime * time.Millisecond).
+			SetRetryAfter(retryAfter).
+			AddRetryCondition(isRetryable).
+			OnBeforeRequest(func(client *resty.Client, r *resty.Request) error {
+				username, password, err := credentials(requestHost(client.BaseURL, r.URL))
+				if err != nil {
+					return fmt.Errorf("Error while reading the Dataplane API credentials: %w", err)
+				}
+				r.SetBasicAuth(username, password)
+				return nil
+			}),
 	}
+
+	c.HTTPClient.SetTransport(&observedTransport{
+		base:    c.HTTPClient.GetClient().Transport,
+		observe: c.observe,
+	})
+
+	baseURL := fmt.Sprintf("%s://%s", scheme, serverIP)
+	if apiVersion == APIVersionAuto {
+		version, err := c.detectAPIVersion(baseURL)
+		if err != nil {
+			log.Warn().Err(err).Msgf("The Dataplane API version could not be detected, %s is assumed.", APIVersion2)
+			version = APIVersion2
+		}
+		apiVersion = version
+	}
+
+	routes, err := newRoutes(apiVersion)
+	if err != nil {
+		return nil, err
+	}
+	c.routes = routes
+	c.serverIP = fmt.Sprintf("%s/%s", baseURL, apiVersion)
+	c.HTTPClient.SetBaseURL(c.serverIP)
+	return c, nil
+}
+
+// SetRequestObserver sets the function called after each request attempt, to
+// measure the Dataplane API latency.
+func (c *Client) SetRequestObserver(observer RequestObserver) {
+	c.observer = observer
+}
+
+// request returns a request bound to ctx and named after operation.
+func (c *Client) request(ctx context.Context, operation string) *resty.Request {
+	return c.HTTPClient.R().SetContext(context.WithValue(ctx, operationKey{}, operation))
+}
+
+func (c *Client) observe(operation, status string, duration time.Duration) {
+	if c.observer != nil {
+		c.observer(operation, status, duration)
+	}
+}
+
+// RoundTrip traces and measures a request attempt, retries included one by
+// one, up to the response headers so that streamed responses are measured
+// too. The trace context is passed on to the Dataplane API.
+func (t *observedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	operation, ok := r.Context().Value(operationKey{}).(string)
+	if !ok {
+		return t.base.RoundTrip(r)
+	}
+
+	ctx, span := tracing.StartClient(r.Context(), "dataplane."+operation,
+		attribute.String("http.method", r.Method),
+		attribute.String("http.url", r.URL.Redacted()),
+	)
+	r = r.Clone(ctx)
+	tracing.Inject(ctx, r.Header)
+
+	startedAt := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+		// Client errors such as a missing entry are expected answers.
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+	}
+	t.observe(operation, status, time.Since(startedAt))
+	tracing.End(span, err)
+	return resp, err
 }
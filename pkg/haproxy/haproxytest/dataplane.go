@@ -0,0 +1,73 @@
+package haproxytest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// NewDataplane starts a Dataplane API answering the requests with the
+// fixtures registered for "<method> <request URI>", and 404 otherwise. The
+// fixture files are read from dir. It is closed at the end of the test.
+func NewDataplane(t *testing.T, dir string, fixtures map[string]Fixture) *Dataplane {
+	t.Helper()
+
+	dataplane := &Dataplane{}
+	dataplane.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := r.Method + " " + r.RequestURI
+		dataplane.mu.Lock()
+		dataplane.requests = append(dataplane.requests, Request{Line: request, Header: r.Header.Clone()})
+		dataplane.mu.Unlock()
+
+		response, exists := fixtures[request]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body := []byte(response.Body)
+		if response.File != "" {
+			content, err := os.ReadFile(filepath.Join(dir, response.File))
+			if err != nil {
+				t.Error(err)
+			}
+			body = content
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(response.Status)
+		w.Write(body)
+	}))
+	t.Cleanup(dataplane.server.Close)
+	return dataplane
+}
+
+// Host returns the address of the Dataplane API, as given to
+// haproxy.NewClient.
+func (d *Dataplane) Host() string {
+	return strings.TrimPrefix(d.server.URL, "http://")
+}
+
+// Close stops the Dataplane API, so that it cannot be reached anymore.
+func (d *Dataplane) Close() {
+	d.server.Close()
+}
+
+// Requests returns the requests received so far.
+func (d *Dataplane) Requests() []Request {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]Request{}, d.requests...)
+}
+
+// RequestLines returns the "<method> <request URI>" of the requests received
+// so far.
+func (d *Dataplane) RequestLines() []string {
+	lines := []string{}
+	for _, request := range d.Requests() {
+		lines = append(lines, request.Line)
+	}
+	return lines
+}
@@ -0,0 +1,344 @@
+// Package haproxytest provides an in-memory HAProxy map backend to test the
+// code using a haproxy.MapBackend without HAProxy, and a Dataplane API
+// answering recorded responses to test the Dataplane client.
+package haproxytest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+)
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		errors: make(map[string]error),
+		maps:   make(map[string][]haproxy.MapEntrie),
+		files:  make(map[string]bool),
+	}
+}
+
+// SetLatency delays every operation by the given duration.
+func (f *FakeBackend) SetLatency(latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.latency = latency
+}
+
+// FailOn makes an operation, named after its method (e.g. "CreateMapEntrie"),
+// return the given error. A nil error restores the operation.
+func (f *FakeBackend) FailOn(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.errors, operation)
+		return
+	}
+	f.errors[operation] = err
+}
+
+// LoadMap loads a runtime map with the given entries, keeping duplicate keys
+// as the HAProxy runtime does.
+func (f *FakeBackend) LoadMap(mapName string, entries ...haproxy.MapEntrie) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.maps[mapName] = []haproxy.MapEntrie{}
+	for _, entrie := range entries {
+		f.add(mapName, entrie)
+	}
+}
+
+// Entries returns the map entries of alternating keys and values.
+func Entries(keyValues ...string) []haproxy.MapEntrie {
+	result := []haproxy.MapEntrie{}
+	for i := 0; i < len(keyValues); i += 2 {
+		result = append(result, haproxy.MapEntrie{Key: keyValues[i], Value: keyValues[i+1]})
+	}
+	return result
+}
+
+func (f *FakeBackend) StreamMapEntries(ctx context.Context, mapName string, fn func(haproxy.MapEntrie) error) error {
+	f.mu.Lock()
+	if err := f.operation(ctx, "StreamMapEntries"); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	entries, exists := f.maps[mapName]
+	entries = append([]haproxy.MapEntrie{}, entries...)
+	f.mu.Unlock()
+
+	if !exists {
+		return haproxy.ErrMapNotFound
+	}
+	for _, entrie := range entries {
+		if err := fn(entrie); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FakeBackend) GetMapEntries(ctx context.Context, mapName string) (*[]haproxy.MapEntrie, error) {
+	mapEntrie := []haproxy.MapEntrie{}
+	err := f.StreamMapEntries(ctx, mapName, func(entrie haproxy.MapEntrie) error {
+		mapEntrie = append(mapEntrie, entrie)
+		return nil
+	})
+	return &mapEntrie, err
+}
+
+func (f *FakeBackend) GetMapEntrie(ctx context.Context, key, mapName string) (*haproxy.MapEntrie, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "GetMapEntrie"); err != nil {
+		return nil, err
+	}
+	for _, entrie := range f.maps[mapName] {
+		if entrie.Key == key {
+			return &entrie, nil
+		}
+	}
+	return nil, haproxy.ErrMapEntrieNotFound
+}
+
+// CreateMapEntrie adds an entry, or returns haproxy.ErrMapEntrieAlreadyExists
+// like the Dataplane API. Duplicate keys can only be loaded with LoadMap.
+func (f *FakeBackend) CreateMapEntrie(ctx context.Context, entrie *haproxy.MapEntrie, mapName string) (*haproxy.MapEntrie, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "CreateMapEntrie"); err != nil {
+		return &haproxy.MapEntrie{}, err
+	}
+	if _, exists := f.maps[mapName]; !exists {
+		return &haproxy.MapEntrie{}, haproxy.ErrMapNotFound
+	}
+	for _, existing := range f.maps[mapName] {
+		if existing.Key == entrie.Key {
+			return &haproxy.MapEntrie{}, haproxy.ErrMapEntrieAlreadyExists
+		}
+	}
+	created := f.add(mapName, *entrie)
+	return &created, nil
+}
+
+// UpdateMapEntrie sets the value of every occurrence of a key.
+func (f *FakeBackend) UpdateMapEntrie(ctx context.Context, entrie *haproxy.MapEntrie, mapName string) (*haproxy.MapEntrie, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "UpdateMapEntrie"); err != nil {
+		return &haproxy.MapEntrie{}, err
+	}
+	updated := false
+	for i := range f.maps[mapName] {
+		if f.maps[mapName][i].Key == entrie.Key {
+			f.maps[mapName][i].Value = entrie.Value
+			updated = true
+		}
+	}
+	if !updated {
+		return &haproxy.MapEntrie{}, haproxy.ErrMapEntrieNotFound
+	}
+	return &haproxy.MapEntrie{Key: entrie.Key, Value: entrie.Value}, nil
+}
+
+// DeleteMapEntrie deletes every occurrence of a key.
+func (f *FakeBackend) DeleteMapEntrie(ctx context.Context, entrie *haproxy.MapEntrie, mapName string) (*haproxy.MapEntrie, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "DeleteMapEntrie"); err != nil {
+		return &haproxy.MapEntrie{}, err
+	}
+	if !f.remove(mapName, func(e haproxy.MapEntrie) bool { return e.Key == entrie.Key }) {
+		return &haproxy.MapEntrie{}, haproxy.ErrMapEntrieNotFound
+	}
+	return &haproxy.MapEntrie{}, nil
+}
+
+func (f *FakeBackend) DeleteMapEntrieById(ctx context.Context, id, mapName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "DeleteMapEntrieById"); err != nil {
+		return err
+	}
+	if !f.remove(mapName, func(e haproxy.MapEntrie) bool { return e.Id == id }) {
+		return haproxy.ErrMapEntrieNotFound
+	}
+	return nil
+}
+
+// ReplaceMapEntries replaces every entry of a map at once.
+func (f *FakeBackend) ReplaceMapEntries(ctx context.Context, mapName string, entries []haproxy.MapEntrie) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "ReplaceMapEntries"); err != nil {
+		return err
+	}
+	if _, exists := f.maps[mapName]; !exists {
+		return haproxy.ErrMapNotFound
+	}
+	f.maps[mapName] = []haproxy.MapEntrie{}
+	for _, entrie := range entries {
+		f.add(mapName, entrie)
+	}
+	return nil
+}
+
+// ReplacesAtomically reports that ReplaceMapEntries swaps every entry at once,
+// like the Runtime API backend.
+func (f *FakeBackend) ReplacesAtomically() bool {
+	return true
+}
+
+func (f *FakeBackend) ListMaps(ctx context.Context) (*[]haproxy.Map, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	maps := []haproxy.Map{}
+	if err := f.operation(ctx, "ListMaps"); err != nil {
+		return &maps, err
+	}
+	for mapName := range f.maps {
+		maps = append(maps, f.runtimeMap(mapName))
+	}
+	return &maps, nil
+}
+
+func (f *FakeBackend) GetMap(ctx context.Context, mapName string) (*haproxy.Map, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "GetMap"); err != nil {
+		return nil, err
+	}
+	if _, exists := f.maps[mapName]; !exists {
+		return nil, haproxy.ErrMapNotFound
+	}
+	runtimeMap := f.runtimeMap(mapName)
+	return &runtimeMap, nil
+}
+
+func (f *FakeBackend) ClearMap(ctx context.Context, mapName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "ClearMap"); err != nil {
+		return err
+	}
+	if _, exists := f.maps[mapName]; !exists {
+		return haproxy.ErrMapNotFound
+	}
+	f.maps[mapName] = []haproxy.MapEntrie{}
+	return nil
+}
+
+// CreateMap creates a map file, loaded at once in the fake runtime.
+func (f *FakeBackend) CreateMap(ctx context.Context, mapName string, entries []haproxy.MapEntrie) (*haproxy.StorageMap, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "CreateMap"); err != nil {
+		return nil, err
+	}
+	if f.files[mapName] {
+		return nil, haproxy.ErrMapAlreadyExists
+	}
+	for _, entrie := range entries {
+		if err := haproxy.ValidateMapFileEntrie(entrie); err != nil {
+			return nil, err
+		}
+	}
+	f.files[mapName] = true
+	f.maps[mapName] = []haproxy.MapEntrie{}
+	for _, entrie := range entries {
+		f.add(mapName, entrie)
+	}
+	return &haproxy.StorageMap{
+		File:        fakeMapFile(mapName),
+		Id:          mapName + ".map",
+		StorageName: mapName + ".map",
+		Size:        int64(len(entries)),
+	}, nil
+}
+
+// DeleteMap deletes a map file created with CreateMap.
+func (f *FakeBackend) DeleteMap(ctx context.Context, mapName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.operation(ctx, "DeleteMap"); err != nil {
+		return err
+	}
+	if !f.files[mapName] {
+		return haproxy.ErrMapNotFound
+	}
+	delete(f.files, mapName)
+	delete(f.maps, mapName)
+	return nil
+}
+
+// IsMapReferenced always reports false: the fake has no configuration.
+func (f *FakeBackend) IsMapReferenced(ctx context.Context, mapName string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return false, f.operation(ctx, "IsMapReferenced")
+}
+
+// operation simulates the latency and the configured failure of an
+// operation, and fails once ctx is done. It is called with the lock held, so
+// that the latency also serializes the operations as a single HAProxy process
+// would.
+func (f *FakeBackend) operation(ctx context.Context, name string) error {
+	if f.latency > 0 {
+		select {
+		case <-time.After(f.latency):
+		case <-ctx.Done():
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return f.errors[name]
+}
+
+func (f *FakeBackend) add(mapName string, entrie haproxy.MapEntrie) haproxy.MapEntrie {
+	f.nextId++
+	created := haproxy.MapEntrie{Id: fmt.Sprintf("0x%x", f.nextId), Key: entrie.Key, Value: entrie.Value}
+	f.maps[mapName] = append(f.maps[mapName], created)
+	return created
+}
+
+func (f *FakeBackend) remove(mapName string, match func(haproxy.MapEntrie) bool) bool {
+	kept := []haproxy.MapEntrie{}
+	for _, entrie := range f.maps[mapName] {
+		if !match(entrie) {
+			kept = append(kept, entrie)
+		}
+	}
+	removed := len(kept) != len(f.maps[mapName])
+	f.maps[mapName] = kept
+	return removed
+}
+
+func (f *FakeBackend) runtimeMap(mapName string) haproxy.Map {
+	return haproxy.Map{
+		Description: fmt.Sprintf("pattern loaded from file '%s' used by map. entry_cnt=%d", fakeMapFile(mapName), len(f.maps[mapName])),
+		File:        fakeMapFile(mapName),
+		Id:          mapName,
+		Size:        int64(len(f.maps[mapName])),
+	}
+}
+
+func fakeMapFile(mapName string) string {
+	return "/etc/haproxy/maps/" + mapName + ".map"
+}
@@ -0,0 +1,43 @@
+package haproxytest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+)
+
+// FakeBackend is an in-memory haproxy.MapBackend. It can simulate latency,
+// failing operations and duplicate keys.
+type FakeBackend struct {
+	latency time.Duration
+	errors  map[string]error
+	maps    map[string][]haproxy.MapEntrie
+	files   map[string]bool
+	nextId  int64
+	mu      sync.Mutex
+}
+
+// Dataplane is a Dataplane API answering recorded responses.
+type Dataplane struct {
+	server   *httptest.Server
+	requests []Request
+	mu       sync.Mutex
+}
+
+// Fixture is a response of the Dataplane API. The content of File, when set,
+// is answered instead of Body.
+type Fixture struct {
+	Status int
+	File   string
+	Body   string
+}
+
+// Request is a request received by a Dataplane.
+type Request struct {
+	// Line is "<method> <request URI>".
+	Line   string
+	Header http.Header
+}
@@ -1,48 +1,95 @@
 package haproxy
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 
 	"github.com/rs/zerolog/log"
 )
 
-var controllerUrl = "/services/haproxy/runtime/maps_entries"
-
-func (c *Client) GetMapEntries(mapName string) (*[]MapEntrie, error) {
+// GetMapEntries loads every entry of a map. Prefer StreamMapEntries for
+// large maps.
+func (c *Client) GetMapEntries(ctx context.Context, mapName string) (*[]MapEntrie, error) {
 	mapEntrie := []MapEntrie{}
-	url := fmt.Sprintf(
-		"%s?map=%s",
-		controllerUrl,
-		mapName,
-	)
-	resp, err := c.HTTPClient.R().
+	err := c.StreamMapEntries(ctx, mapName, func(entrie MapEntrie) error {
+		mapEntrie = append(mapEntrie, entrie)
+		return nil
+	})
+	return &mapEntrie, err
+}
+
+// StreamMapEntries decodes the entries of a map one by one from the Dataplane
+// response and calls fn for each of them, without loading the whole map in
+// memory. An error returned by fn stops the stream.
+func (c *Client) StreamMapEntries(ctx context.Context, mapName string, fn func(MapEntrie) error) error {
+	url := c.routes.MapEntries(mapName)
+	resp, err := c.request(ctx, "get_entries").
+		SetDoNotParseResponse(true).
+		Get(url)
+
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+		return err
+	}
+
+	body := resp.RawBody()
+	defer body.Close()
+
+	if resp.StatusCode() != http.StatusOK {
+		log.Debug().Msgf("Error while getting mapEntrie. Status code %d", resp.StatusCode())
+		content, _ := io.ReadAll(io.LimitReader(body, maxErrorBodySize))
+		return decodeAPIError("getting mapEntrie", resp.StatusCode(), resp.Status(), content)
+	}
+
+	decoder := json.NewDecoder(body)
+	if err := expectDelim(decoder, '['); err != nil {
+		return err
+	}
+	for decoder.More() {
+		entrie := MapEntrie{}
+		if err := decoder.Decode(&entrie); err != nil {
+			return fmt.Errorf("Error while decoding mapEntrie: %w", err)
+		}
+		if err := fn(entrie); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, ']')
+}
+
+// GetMapEntrie returns the entry of a map by key, or ErrMapEntrieNotFound.
+func (c *Client) GetMapEntrie(ctx context.Context, key, mapName string) (*MapEntrie, error) {
+	url := c.routes.MapEntrie(key, mapName)
+	mapEntrie := MapEntrie{}
+	resp, err := c.request(ctx, "get_entry").
 		SetResult(&mapEntrie).
 		Get(url)
 
 	if err != nil {
 		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
-		return &mapEntrie, err
+		return nil, err
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return nil, ErrMapEntrieNotFound
 	}
 
 	if resp.StatusCode() != http.StatusOK {
 		log.Debug().Msgf("Error while getting mapEntrie. Status code %d", resp.StatusCode())
-		return &mapEntrie, fmt.Errorf("Error while getting mapEntrie: %s", resp.Status())
+		return nil, newAPIError("getting mapEntrie", resp)
 	}
 
 	return &mapEntrie, nil
-
 }
 
-func (c *Client) CreateMapEntrie(entrie *MapEntrie, mapName string) (*MapEntrie, error) {
-	url := fmt.Sprintf(
-		"%s?map=%s&force_sync=true",
-		controllerUrl,
-		mapName,
-	)
+func (c *Client) CreateMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error) {
+	url := withForceSync(c.routes.MapEntries(mapName))
 	mapEntrie := MapEntrie{}
-	resp, err := c.HTTPClient.R().
-		SetBody(entrie).
+	resp, err := c.request(ctx, "create_entry").
+		SetBody(MapEntrie{Key: entrie.Key, Value: entrie.Value}).
 		SetResult(mapEntrie).
 		Post(url)
 
@@ -51,24 +98,23 @@ func (c *Client) CreateMapEntrie(entrie *MapEntrie, mapName string) (*MapEntrie,
 		return &mapEntrie, err
 	}
 
+	if resp.StatusCode() == http.StatusConflict {
+		return &mapEntrie, ErrMapEntrieAlreadyExists
+	}
+
 	if resp.StatusCode() != http.StatusCreated {
 		log.Debug().Msgf("Error while creating mapEntrie. Status code %d", resp.StatusCode())
-		return &mapEntrie, fmt.Errorf("Error while creating mapEntrie: %s", resp.Status())
+		return &mapEntrie, newAPIError("creating mapEntrie", resp)
 	}
 
 	return &mapEntrie, nil
 }
 
-func (c *Client) UpdateMapEntrie(entrie *MapEntrie, mapName string) (*MapEntrie, error) {
-	url := fmt.Sprintf(
-		"%s/%s?map=%s&force_sync=true",
-		controllerUrl,
-		encodeUrl(entrie.Key),
-		mapName,
-	)
+func (c *Client) UpdateMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error) {
+	url := withForceSync(c.routes.MapEntrie(entrie.Key, mapName))
 	mapEntrie := MapEntrie{}
-	resp, err := c.HTTPClient.R().
-		SetBody(entrie).
+	resp, err := c.request(ctx, "update_entry").
+		SetBody(MapEntrie{Key: entrie.Key, Value: entrie.Value}).
 		SetResult(mapEntrie).
 		Put(url)
 
@@ -77,24 +123,23 @@ func (c *Client) UpdateMapEntrie(entrie *MapEntrie, mapName string) (*MapEntrie,
 		return &mapEntrie, err
 	}
 
+	if resp.StatusCode() == http.StatusNotFound {
+		return &mapEntrie, ErrMapEntrieNotFound
+	}
+
 	if resp.StatusCode() != http.StatusOK {
 		log.Debug().Msgf("Error while updating mapEntrie. Status code %d", resp.StatusCode())
-		return &mapEntrie, fmt.Errorf("Error while updating mapEntrie: %s", resp.Status())
+		return &mapEntrie, newAPIError("updating mapEntrie", resp)
 	}
 
 	return &mapEntrie, nil
 }
 
-func (c *Client) DeleteMapEntrie(entrie *MapEntrie, mapName string) (*MapEntrie, error) {
-	url := fmt.Sprintf(
-		"%s/%s?map=%s&force_sync=true",
-		controllerUrl,
-		encodeUrl(entrie.Key),
-		mapName,
-	)
+func (c *Client) DeleteMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error) {
+	url := withForceSync(c.routes.MapEntrie(entrie.Key, mapName))
 	mapEntrie := MapEntrie{}
-	resp, err := c.HTTPClient.R().
-		SetBody(entrie).
+	resp, err := c.request(ctx, "delete_entry").
+		SetBody(MapEntrie{Key: entrie.Key, Value: entrie.Value}).
 		SetResult(mapEntrie).
 		Delete(url)
 
@@ -103,10 +148,22 @@ func (c *Client) DeleteMapEntrie(entrie *MapEntrie, mapName string) (*MapEntrie,
 		return &mapEntrie, err
 	}
 
+	if resp.StatusCode() == http.StatusNotFound {
+		return &mapEntrie, ErrMapEntrieNotFound
+	}
+
 	if resp.StatusCode() != http.StatusNoContent {
 		log.Debug().Msgf("Error while deleting mapEntrie. Status code %d", resp.StatusCode())
-		return &mapEntrie, fmt.Errorf("Error while deleting mapEntrie: %s", resp.Status())
+		return &mapEntrie, newAPIError("deleting mapEntrie", resp)
 	}
 
 	return &mapEntrie, nil
 }
+
+// DeleteMapEntrieById deletes a single occurrence of a key through its
+// runtime id, leaving the other occurrences of the key in place.
+func (c *Client) DeleteMapEntrieById(ctx context.Context, id, mapName string) error {
+	// HAProxy reads a key starting with '#' as an entry reference.
+	_, err := c.DeleteMapEntrie(ctx, &MapEntrie{Key: "#" + id}, mapName)
+	return err
+}
@@ -0,0 +1,107 @@
+package haproxy
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var entryCountPattern = regexp.MustCompile(`entry_cnt=(\d+)`)
+
+// ListMaps returns the maps loaded in the HAProxy runtime.
+func (c *Client) ListMaps(ctx context.Context) (*[]Map, error) {
+	maps := []Map{}
+	resp, err := c.request(ctx, "list_maps").
+		SetResult(&maps).
+		Get(c.routes.Maps())
+
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+		return &maps, err
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		log.Debug().Msgf("Error while listing maps. Status code %d", resp.StatusCode())
+		return &maps, newAPIError("listing maps", resp)
+	}
+
+	return &maps, nil
+}
+
+// GetMap returns a map loaded in the HAProxy runtime, or ErrMapNotFound.
+func (c *Client) GetMap(ctx context.Context, mapName string) (*Map, error) {
+	runtimeMap := Map{}
+	resp, err := c.request(ctx, "get_map").
+		SetResult(&runtimeMap).
+		Get(c.routes.Map(mapName))
+
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+		return nil, err
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return nil, ErrMapNotFound
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		log.Debug().Msgf("Error while getting map. Status code %d", resp.StatusCode())
+		return nil, newAPIError("getting map", resp)
+	}
+
+	return &runtimeMap, nil
+}
+
+// Name returns the name of the map used by the maps entries endpoints: its
+// file name without the .map extension.
+func (m *Map) Name() string {
+	return strings.TrimSuffix(path.Base(m.File), ".map")
+}
+
+// EntryCount returns the number of entries reported by the runtime in the
+// map description, or the size of the map when it is missing.
+func (m *Map) EntryCount() int64 {
+	if match := entryCountPattern.FindStringSubmatch(m.Description); match != nil {
+		if count, err := strconv.ParseInt(match[1], 10, 64); err == nil {
+			return count
+		}
+	}
+	return m.Size
+}
+
+// ReplaceMapEntries replaces every entry of a map: the map is cleared, then
+// the entries are added in a single payload. Unlike with the Runtime API
+// backend, the replacement is not atomic.
+func (c *Client) ReplaceMapEntries(ctx context.Context, mapName string, entries []MapEntrie) error {
+	if err := c.ClearMap(ctx, mapName); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	payload := make([]MapEntrie, 0, len(entries))
+	for _, entrie := range entries {
+		payload = append(payload, MapEntrie{Key: entrie.Key, Value: entrie.Value})
+	}
+	resp, err := c.request(ctx, "replace_entries").
+		SetBody(payload).
+		Put(withForceSync(c.routes.ReplaceEntries(mapName)))
+
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+		return err
+	}
+
+	if resp.StatusCode() != http.StatusCreated {
+		log.Debug().Msgf("Error while replacing map entries. Status code %d", resp.StatusCode())
+		return newAPIError("replacing map entries", resp)
+	}
+
+	return nil
+}
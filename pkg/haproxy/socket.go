@@ -0,0 +1,477 @@
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// NewSocketClient returns a Runtime API client for a stats socket address:
+// a unix socket path, "unix://<path>" or "tcp://<host>:<port>". The socket
+// must be exposed with "level admin".
+func NewSocketClient(address string) *SocketClient {
+	network := "unix"
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		network, address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		address = strings.TrimPrefix(address, "unix://")
+	}
+
+	return &SocketClient{
+		network:    network,
+		address:    address,
+		timeout:    runtimeAPITimeout * time.Second,
+		references: make(map[string]string),
+	}
+}
+
+// StreamMapEntries reads the entries of a map one by one from the output of
+// "show map" and calls fn for each of them. An error returned by fn stops
+// the stream.
+func (s *SocketClient) StreamMapEntries(ctx context.Context, mapName string, fn func(MapEntrie) error) error {
+	return s.withMapReference(ctx, mapName, func(ref string) error {
+		command, err := formatCommand("show map", ref)
+		if err != nil {
+			return err
+		}
+		return s.stream(ctx, command, func(line string) error {
+			entrie, err := parseMapEntrie(line)
+			if err != nil {
+				return err
+			}
+			return fn(entrie)
+		})
+	})
+}
+
+// GetMapEntries loads every entry of a map. Prefer StreamMapEntries for
+// large maps.
+func (s *SocketClient) GetMapEntries(ctx context.Context, mapName string) (*[]MapEntrie, error) {
+	mapEntrie := []MapEntrie{}
+	err := s.StreamMapEntries(ctx, mapName, func(entrie MapEntrie) error {
+		mapEntrie = append(mapEntrie, entrie)
+		return nil
+	})
+	return &mapEntrie, err
+}
+
+// GetMapEntrie returns the entry of a map by key, or ErrMapEntrieNotFound.
+// "get map" looks the key up as a sample, so its answer is only used when it
+// matched the key itself, or when it found nothing in a map matching exact
+// strings. Otherwise, for instance when a regular expression matched first,
+// the map is scanned.
+func (s *SocketClient) GetMapEntrie(ctx context.Context, key, mapName string) (*MapEntrie, error) {
+	var lookup mapLookup
+	err := s.withMapReference(ctx, mapName, func(ref string) error {
+		command, err := formatCommand("get map", ref, key)
+		if err != nil {
+			return err
+		}
+		output, err := s.command(ctx, command)
+		if err != nil {
+			return err
+		}
+		lookup, err = parseMapLookup(output)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case lookup.Found && lookup.Key == key:
+		return &MapEntrie{Key: key, Value: lookup.Value}, nil
+	case !lookup.Found && lookup.Type == "str":
+		return nil, ErrMapEntrieNotFound
+	}
+
+	var found *MapEntrie
+	err = s.StreamMapEntries(ctx, mapName, func(entrie MapEntrie) error {
+		if entrie.Key == key {
+			found = &entrie
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrMapEntrieNotFound
+	}
+	return found, nil
+}
+
+// CreateMapEntrie adds an entry, or returns ErrMapEntrieAlreadyExists. "add
+// map" never fails on an existing key, so the key is looked up first; a
+// duplicate left by a concurrent creation is deleted by the next
+// synchronization.
+func (s *SocketClient) CreateMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error) {
+	_, err := s.GetMapEntrie(ctx, entrie.Key, mapName)
+	if err == nil {
+		return &MapEntrie{}, ErrMapEntrieAlreadyExists
+	}
+	if err != ErrMapEntrieNotFound {
+		return &MapEntrie{}, err
+	}
+
+	err = s.executeOnMap(ctx, mapName, "add map", entrie.Key, entrie.Value)
+	if err != nil {
+		return &MapEntrie{}, fmt.Errorf("Error while creating mapEntrie: %w", err)
+	}
+	return &MapEntrie{Key: entrie.Key, Value: entrie.Value}, nil
+}
+
+func (s *SocketClient) UpdateMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error) {
+	err := s.executeOnMap(ctx, mapName, "set map", entrie.Key, entrie.Value)
+	if err == ErrMapEntrieNotFound || err == ErrMapNotFound {
+		return &MapEntrie{}, err
+	}
+	if err != nil {
+		return &MapEntrie{}, fmt.Errorf("Error while updating mapEntrie: %w", err)
+	}
+	return &MapEntrie{Key: entrie.Key, Value: entrie.Value}, nil
+}
+
+func (s *SocketClient) DeleteMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error) {
+	err := s.executeOnMap(ctx, mapName, "del map", entrie.Key)
+	if err == ErrMapEntrieNotFound || err == ErrMapNotFound {
+		return &MapEntrie{}, err
+	}
+	if err != nil {
+		return &MapEntrie{}, fmt.Errorf("Error while deleting mapEntrie: %w", err)
+	}
+	return &MapEntrie{}, nil
+}
+
+// DeleteMapEntrieById deletes a single occurrence of a key through its
+// runtime id, leaving the other occurrences of the key in place.
+func (s *SocketClient) DeleteMapEntrieById(ctx context.Context, id, mapName string) error {
+	_, err := s.DeleteMapEntrie(ctx, &MapEntrie{Key: "#" + id}, mapName)
+	return err
+}
+
+// ReplaceMapEntries atomically replaces the entries of a map: the entries are
+// loaded into a new version of the map with "prepare map", which is then
+// swapped in with "commit map". HAProxy keeps serving the previous entries
+// until the commit, and drops an uncommitted version on the next prepare.
+func (s *SocketClient) ReplaceMapEntries(ctx context.Context, mapName string, entries []MapEntrie) error {
+	return s.withMapReference(ctx, mapName, func(ref string) error {
+		command, err := formatCommand("prepare map", ref)
+		if err != nil {
+			return err
+		}
+		output, err := s.command(ctx, command)
+		if err != nil {
+			return err
+		}
+		version := ""
+		if _, err := fmt.Sscanf(output, "New version created: %s", &version); err != nil {
+			if err := runtimeError(output); err != nil {
+				return err
+			}
+			return fmt.Errorf("Error while preparing map: %s", strings.TrimSpace(output))
+		}
+
+		// Commands are batched on a single line, separated by semicolons.
+		batch := strings.Builder{}
+		for _, entrie := range entries {
+			command, err := formatCommand("add map @"+version, ref, entrie.Key, entrie.Value)
+			if err != nil {
+				return err
+			}
+			if batch.Len() > 0 && batch.Len()+len(command)+1 > maxCommandLength {
+				if err := s.execute(ctx, batch.String()); err != nil {
+					return fmt.Errorf("Error while preparing map: %w", err)
+				}
+				batch.Reset()
+			}
+			if batch.Len() > 0 {
+				batch.WriteString(";")
+			}
+			batch.WriteString(command)
+		}
+		if batch.Len() > 0 {
+			if err := s.execute(ctx, batch.String()); err != nil {
+				return fmt.Errorf("Error while preparing map: %w", err)
+			}
+		}
+
+		command, err = formatCommand("commit map @"+version, ref)
+		if err != nil {
+			return err
+		}
+		if err := s.execute(ctx, command); err != nil {
+			return fmt.Errorf("Error while committing map: %w", err)
+		}
+		return nil
+	})
+}
+
+// ReplacesAtomically reports that ReplaceMapEntries swaps every entry at once.
+func (s *SocketClient) ReplacesAtomically() bool {
+	return true
+}
+
+// ListMaps returns the maps loaded in the HAProxy runtime.
+func (s *SocketClient) ListMaps(ctx context.Context) (*[]Map, error) {
+	maps := []Map{}
+	err := s.stream(ctx, "show map", func(line string) error {
+		if strings.HasPrefix(line, "#") {
+			return nil
+		}
+		runtimeMap, err := parseMap(line)
+		if err != nil {
+			return err
+		}
+		maps = append(maps, runtimeMap)
+		return nil
+	})
+	if err != nil {
+		return &maps, err
+	}
+	return &maps, nil
+}
+
+// GetMap returns a map loaded in the HAProxy runtime, or ErrMapNotFound.
+func (s *SocketClient) GetMap(ctx context.Context, mapName string) (*Map, error) {
+	maps, err := s.ListMaps(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, runtimeMap := range *maps {
+		if runtimeMap.Name() == mapName {
+			return &runtimeMap, nil
+		}
+	}
+	return nil, ErrMapNotFound
+}
+
+// ClearMap removes every entry of a runtime map.
+func (s *SocketClient) ClearMap(ctx context.Context, mapName string) error {
+	err := s.executeOnMap(ctx, mapName, "clear map")
+	if err == ErrMapNotFound {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("Error while clearing map: %w", err)
+	}
+	return nil
+}
+
+// executeOnMap runs a command taking the map reference then args, expected to
+// print nothing on success.
+func (s *SocketClient) executeOnMap(ctx context.Context, mapName, name string, args ...string) error {
+	return s.withMapReference(ctx, mapName, func(ref string) error {
+		command, err := formatCommand(name, append([]string{ref}, args...)...)
+		if err != nil {
+			return err
+		}
+		return s.execute(ctx, command)
+	})
+}
+
+// withMapReference calls fn with the file of a map, which the Runtime API
+// uses to identify it. The files are cached, so that a synchronization does
+// not list the maps for every entry. A cached file that HAProxy no longer
+// knows, after a reload for instance, is looked up again once.
+func (s *SocketClient) withMapReference(ctx context.Context, mapName string, fn func(ref string) error) error {
+	s.mu.Lock()
+	ref, cached := s.references[mapName]
+	s.mu.Unlock()
+
+	if cached {
+		err := fn(ref)
+		if err != ErrMapNotFound {
+			return err
+		}
+		s.mu.Lock()
+		delete(s.references, mapName)
+		s.mu.Unlock()
+	}
+
+	runtimeMap, err := s.GetMap(ctx, mapName)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.references[mapName] = runtimeMap.File
+	s.mu.Unlock()
+	return fn(runtimeMap.File)
+}
+
+// execute runs a command expected to print nothing on success.
+func (s *SocketClient) execute(ctx context.Context, command string) error {
+	output, err := s.command(ctx, command)
+	if err != nil {
+		return err
+	}
+	return runtimeError(output)
+}
+
+// command runs a command and returns its whole output.
+func (s *SocketClient) command(ctx context.Context, command string) (string, error) {
+	output := strings.Builder{}
+	err := s.stream(ctx, command, func(line string) error {
+		output.WriteString(line)
+		output.WriteString("\n")
+		return nil
+	})
+	return output.String(), err
+}
+
+// stream runs a command and calls fn for each non-empty line of its output.
+// The socket is used in non-interactive mode: HAProxy closes the connection
+// once the command output is written.
+func (s *SocketClient) stream(ctx context.Context, command string, fn func(line string) error) error {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling Runtime API.")
+		return err
+	}
+	defer conn.Close()
+
+	// Closing the connection interrupts the pending read or write once ctx
+	// is done.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		log.Debug().Err(err).Msg("Error while calling Runtime API.")
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.timeout))
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return scanner.Err()
+}
+
+// runtimeError converts the output of a failed command to an error.
+func runtimeError(output string) error {
+	message := strings.TrimSpace(output)
+	lower := strings.ToLower(message)
+	switch {
+	case message == "":
+		return nil
+	case strings.Contains(lower, "unknown map identifier"):
+		return ErrMapNotFound
+	case strings.Contains(lower, "not found"):
+		return ErrMapEntrieNotFound
+	default:
+		return fmt.Errorf("Runtime API error: %s", message)
+	}
+}
+
+// parseMapEntrie parses a "show map <map>" line: "<id> <key> <value>".
+func parseMapEntrie(line string) (MapEntrie, error) {
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "0x") {
+		return MapEntrie{}, runtimeError(line)
+	}
+	entrie := MapEntrie{Id: fields[0], Key: fields[1]}
+	if len(fields) == 3 {
+		entrie.Value = fields[2]
+	}
+	return entrie, nil
+}
+
+// parseMapLookup parses the output of "get map <map> <key>":
+// `type=str, case=sensitive, found=yes, idx=tree, key="k", value="v", type="str"`,
+// or `type=str, case=sensitive, found=no`.
+func parseMapLookup(output string) (mapLookup, error) {
+	line := strings.TrimSpace(output)
+	if !strings.HasPrefix(line, "type=") {
+		return mapLookup{}, runtimeError(line)
+	}
+
+	lookup := mapLookup{}
+	lookup.Type, _, _ = strings.Cut(strings.TrimPrefix(line, "type="), ",")
+	lookup.Found = strings.Contains(line, ", found=yes")
+	if !lookup.Found {
+		return lookup, nil
+	}
+
+	// The key and the value are quoted without escaping, so the value is
+	// delimited by the last type field.
+	_, rest, found := strings.Cut(line, `, key="`)
+	if !found {
+		return mapLookup{}, fmt.Errorf("Runtime API error: unexpected map lookup %s", line)
+	}
+	lookup.Key, rest, _ = strings.Cut(rest, `", value="`)
+	if end := strings.LastIndex(rest, `", type="`); end >= 0 {
+		lookup.Value = rest[:end]
+	} else {
+		lookup.Value = strings.TrimSuffix(rest, `"`)
+	}
+	return lookup, nil
+}
+
+// parseMap parses a "show map" line: "<id> (<file>) <description>".
+func parseMap(line string) (Map, error) {
+	id, rest, _ := strings.Cut(line, " ")
+	if !strings.HasPrefix(rest, "(") {
+		return Map{}, runtimeError(line)
+	}
+	file, description, found := strings.Cut(rest[1:], ")")
+	if !found {
+		return Map{}, runtimeError(line)
+	}
+	return Map{Id: id, File: file, Description: strings.TrimSpace(description)}, nil
+}
+
+// formatCommand escapes the arguments of a Runtime API command. Arguments
+// holding a line break are rejected, since HAProxy would run the rest of the
+// line as another command.
+func formatCommand(name string, args ...string) (string, error) {
+	command := strings.Builder{}
+	command.WriteString(name)
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return "", fmt.Errorf("%w: %q holds a line break", ErrInvalidMapEntrie, arg)
+		}
+		command.WriteString(" ")
+		command.WriteString(escapeArg(arg))
+	}
+	return command.String(), nil
+}
+
+// escapeArg escapes the characters splitting the Runtime API arguments and
+// commands. Line breaks cannot be escaped and are rejected by formatCommand.
+func escapeArg(arg string) string {
+	return strings.NewReplacer(`\`, `\\`, " ", `\ `, "\t", "\\\t", ";", `\;`).Replace(arg)
+}
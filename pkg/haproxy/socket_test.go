@@ -0,0 +1,528 @@
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRuntimeAPI is a Runtime API server holding maps in memory. It answers
+// one command line per connection, like the HAProxy stats socket in
+// non-interactive mode.
+type fakeRuntimeAPI struct {
+	listener net.Listener
+	mu       sync.Mutex
+	maps     []*fakeRuntimeMap
+	lines    []string
+	nextId   int
+}
+
+type fakeRuntimeMap struct {
+	id        int
+	file      string
+	matchType string
+	entries   []MapEntrie
+	versions  map[string][]MapEntrie
+	nextVer   int
+}
+
+func newFakeRuntimeAPI(t *testing.T) *fakeRuntimeAPI {
+	t.Helper()
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "api.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeRuntimeAPI{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (f *fakeRuntimeAPI) client() *SocketClient {
+	return NewSocketClient("unix://" + f.listener.Addr().String())
+}
+
+func (f *fakeRuntimeAPI) addMap(name, matchType string, entries ...MapEntrie) *fakeRuntimeMap {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	runtimeMap := &fakeRuntimeMap{
+		id:        len(f.maps) + 1,
+		file:      fmt.Sprintf("/etc/haproxy/maps/%s.map", name),
+		matchType: matchType,
+		versions:  make(map[string][]MapEntrie),
+	}
+	for _, entrie := range entries {
+		runtimeMap.entries = append(runtimeMap.entries, f.entrie(entrie.Key, entrie.Value))
+	}
+	f.maps = append(f.maps, runtimeMap)
+	return runtimeMap
+}
+
+// moveMap changes the file of a map, as a reload with a new configuration
+// would.
+func (f *fakeRuntimeAPI) moveMap(runtimeMap *fakeRuntimeMap, file string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	runtimeMap.file = file
+}
+
+func (f *fakeRuntimeAPI) entries(runtimeMap *fakeRuntimeMap) []MapEntrie {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := []MapEntrie{}
+	for _, entrie := range runtimeMap.entries {
+		result = append(result, MapEntrie{Key: entrie.Key, Value: entrie.Value})
+	}
+	return result
+}
+
+// commands returns the received command lines starting with prefix.
+func (f *fakeRuntimeAPI) commands(prefix string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	result := []string{}
+	for _, line := range f.lines {
+		if strings.HasPrefix(line, prefix) {
+			result = append(result, line)
+		}
+	}
+	return result
+}
+
+func (f *fakeRuntimeAPI) entrie(key, value string) MapEntrie {
+	f.nextId++
+	return MapEntrie{Id: fmt.Sprintf("0x%x", f.nextId), Key: key, Value: value}
+}
+
+func (f *fakeRuntimeAPI) serve(conn net.Conn) {
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+	line = strings.TrimSuffix(line, "\n")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lines = append(f.lines, line)
+
+	output := strings.Builder{}
+	for _, args := range splitCommandLine(line) {
+		output.WriteString(f.run(args))
+	}
+	conn.Write([]byte(output.String() + "\n"))
+}
+
+func (f *fakeRuntimeAPI) run(args []string) string {
+	command := strings.Join(args[:min(len(args), 2)], " ")
+	if command == "show map" && len(args) == 2 {
+		output := "# id (file) description\n"
+		for _, runtimeMap := range f.maps {
+			output += fmt.Sprintf("%d (%s) pattern loaded from file '%s' used by map at file '/etc/haproxy/haproxy.cfg' line 10. curr_ver=0 next_ver=0 entry_cnt=%d\n",
+				runtimeMap.id, runtimeMap.file, runtimeMap.file, len(runtimeMap.entries))
+		}
+		return output
+	}
+
+	version := ""
+	if len(args) > 2 && strings.HasPrefix(args[2], "@") {
+		version = strings.TrimPrefix(args[2], "@")
+		args = append(args[:2:2], args[3:]...)
+	}
+	if len(args) < 3 {
+		return "Unknown command.\n"
+	}
+	runtimeMap := f.lookupMap(args[2])
+	if runtimeMap == nil {
+		return "Unknown map identifier. Please use #<id> or <file>.\n"
+	}
+
+	switch {
+	case command == "show map":
+		output := ""
+		for _, entrie := range runtimeMap.entries {
+			output += fmt.Sprintf("%s %s %s\n", entrie.Id, entrie.Key, entrie.Value)
+		}
+		return output
+	case command == "get map" && len(args) == 4:
+		if runtimeMap.matchType == "str" {
+			for _, entrie := range runtimeMap.entries {
+				if entrie.Key == args[3] {
+					return fmt.Sprintf("type=str, case=sensitive, found=yes, idx=tree, key=\"%s\", value=\"%s\", type=\"str\"\n", entrie.Key, entrie.Value)
+				}
+			}
+		}
+		return fmt.Sprintf("type=%s, case=sensitive, found=no\n", runtimeMap.matchType)
+	case command == "add map" && len(args) == 5 && version != "":
+		runtimeMap.versions[version] = append(runtimeMap.versions[version], f.entrie(args[3], args[4]))
+	case command == "add map" && len(args) == 5:
+		runtimeMap.entries = append(runtimeMap.entries, f.entrie(args[3], args[4]))
+	case command == "set map" && len(args) == 5:
+		found := false
+		for i := range runtimeMap.entries {
+			if runtimeMap.entries[i].Key == args[3] {
+				runtimeMap.entries[i].Value = args[4]
+				found = true
+			}
+		}
+		if !found {
+			return "entry not found.\n"
+		}
+	case command == "del map" && len(args) == 4:
+		kept := []MapEntrie{}
+		for _, entrie := range runtimeMap.entries {
+			if entrie.Key != args[3] && "#"+entrie.Id != args[3] {
+				kept = append(kept, entrie)
+			}
+		}
+		if len(kept) == len(runtimeMap.entries) {
+			return "Key not found.\n"
+		}
+		runtimeMap.entries = kept
+	case command == "clear map" && len(args) == 3:
+		runtimeMap.entries = nil
+	case command == "prepare map" && len(args) == 3:
+		runtimeMap.nextVer++
+		version := fmt.Sprint(runtimeMap.nextVer)
+		runtimeMap.versions[version] = []MapEntrie{}
+		return fmt.Sprintf("New version created: %s\n", version)
+	case command == "commit map" && len(args) == 3 && version != "":
+		entries, exists := runtimeMap.versions[version]
+		if !exists {
+			return "No such version.\n"
+		}
+		runtimeMap.entries = entries
+		runtimeMap.versions = make(map[string][]MapEntrie)
+	default:
+		return "Unknown command.\n"
+	}
+	return ""
+}
+
+func (f *fakeRuntimeAPI) lookupMap(reference string) *fakeRuntimeMap {
+	for _, runtimeMap := range f.maps {
+		if runtimeMap.file == reference || fmt.Sprintf("#%d", runtimeMap.id) == reference {
+			return runtimeMap
+		}
+	}
+	return nil
+}
+
+// splitCommandLine splits a command line into commands and arguments, on the
+// spaces, tabs and semicolons not escaped with a backslash.
+func splitCommandLine(line string) [][]string {
+	commands := [][]string{}
+	args := []string{}
+	arg := strings.Builder{}
+	pending := false
+	flush := func() {
+		if pending {
+			args = append(args, arg.String())
+			arg.Reset()
+			pending = false
+		}
+	}
+
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			arg.WriteByte(line[i])
+			pending = true
+		case c == ' ' || c == '\t':
+			flush()
+		case c == ';':
+			flush()
+			commands = append(commands, args)
+			args = []string{}
+		default:
+			arg.WriteByte(c)
+			pending = true
+		}
+	}
+	flush()
+	return append(commands, args)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestSocketClientEntries(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	runtimeMap := server.addMap("rate-limits", "str", MapEntrie{Key: "/api", Value: "10"})
+	client := server.client()
+	ctx := context.Background()
+
+	entrie, err := client.GetMapEntrie(ctx, "/api", "rate-limits")
+	if err != nil || entrie.Value != "10" {
+		t.Fatalf("GetMapEntrie = %v, %v, want the /api entry", entrie, err)
+	}
+	if _, err := client.GetMapEntrie(ctx, "/missing", "rate-limits"); err != ErrMapEntrieNotFound {
+		t.Errorf("GetMapEntrie of a missing key = %v, want ErrMapEntrieNotFound", err)
+	}
+
+	if _, err := client.CreateMapEntrie(ctx, &MapEntrie{Key: "/login", Value: "5"}, "rate-limits"); err != nil {
+		t.Fatalf("CreateMapEntrie: %v", err)
+	}
+	if _, err := client.CreateMapEntrie(ctx, &MapEntrie{Key: "/login", Value: "6"}, "rate-limits"); err != ErrMapEntrieAlreadyExists {
+		t.Errorf("CreateMapEntrie of an existing key = %v, want ErrMapEntrieAlreadyExists", err)
+	}
+	if _, err := client.UpdateMapEntrie(ctx, &MapEntrie{Key: "/api", Value: "20"}, "rate-limits"); err != nil {
+		t.Fatalf("UpdateMapEntrie: %v", err)
+	}
+	if _, err := client.UpdateMapEntrie(ctx, &MapEntrie{Key: "/missing", Value: "1"}, "rate-limits"); err != ErrMapEntrieNotFound {
+		t.Errorf("UpdateMapEntrie of a missing key = %v, want ErrMapEntrieNotFound", err)
+	}
+	if _, err := client.DeleteMapEntrie(ctx, &MapEntrie{Key: "/missing"}, "rate-limits"); err != ErrMapEntrieNotFound {
+		t.Errorf("DeleteMapEntrie of a missing key = %v, want ErrMapEntrieNotFound", err)
+	}
+
+	expected := []MapEntrie{{Key: "/api", Value: "20"}, {Key: "/login", Value: "5"}}
+	if got := server.entries(runtimeMap); !reflect.DeepEqual(got, expected) {
+		t.Errorf("map entries = %v, want %v", got, expected)
+	}
+
+	entries, err := client.GetMapEntries(ctx, "rate-limits")
+	if err != nil || len(*entries) != 2 || (*entries)[0].Id == "" {
+		t.Errorf("GetMapEntries = %v, %v, want 2 entries with their id", entries, err)
+	}
+
+	if _, err := client.DeleteMapEntrie(ctx, &MapEntrie{Key: "/api"}, "rate-limits"); err != nil {
+		t.Fatalf("DeleteMapEntrie: %v", err)
+	}
+	if err := client.ClearMap(ctx, "rate-limits"); err != nil {
+		t.Fatalf("ClearMap: %v", err)
+	}
+	if got := server.entries(runtimeMap); len(got) != 0 {
+		t.Errorf("map entries after clear = %v, want none", got)
+	}
+}
+
+func TestSocketClientDeleteMapEntrieById(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	runtimeMap := server.addMap("rate-limits", "str", MapEntrie{Key: "/api", Value: "10"}, MapEntrie{Key: "/api", Value: "20"})
+	client := server.client()
+	ctx := context.Background()
+
+	entries, err := client.GetMapEntries(ctx, "rate-limits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.DeleteMapEntrieById(ctx, (*entries)[1].Id, "rate-limits"); err != nil {
+		t.Fatalf("DeleteMapEntrieById: %v", err)
+	}
+
+	expected := []MapEntrie{{Key: "/api", Value: "10"}}
+	if got := server.entries(runtimeMap); !reflect.DeepEqual(got, expected) {
+		t.Errorf("map entries = %v, want %v", got, expected)
+	}
+}
+
+func TestSocketClientUnknownMap(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	client := server.client()
+	ctx := context.Background()
+
+	if _, err := client.GetMap(ctx, "missing"); err != ErrMapNotFound {
+		t.Errorf("GetMap = %v, want ErrMapNotFound", err)
+	}
+	if _, err := client.UpdateMapEntrie(ctx, &MapEntrie{Key: "a", Value: "b"}, "missing"); err != ErrMapNotFound {
+		t.Errorf("UpdateMapEntrie = %v, want ErrMapNotFound", err)
+	}
+	if err := client.ClearMap(ctx, "missing"); err != ErrMapNotFound {
+		t.Errorf("ClearMap = %v, want ErrMapNotFound", err)
+	}
+}
+
+func TestSocketClientCachesMapReference(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	server.addMap("rate-limits", "str")
+	client := server.client()
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		entrie := &MapEntrie{Key: fmt.Sprintf("/path/%d", i), Value: "1"}
+		if _, err := client.CreateMapEntrie(ctx, entrie, "rate-limits"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.UpdateMapEntrie(ctx, entrie, "rate-limits"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := countExact(server.commands("show map"), "show map"); got != 1 {
+		t.Errorf("the maps were listed %d times, want once", got)
+	}
+	// A creation looks the key up, then adds it. An update is a single command.
+	if got := len(server.lines); got != 1+10*3 {
+		t.Errorf("%d commands were sent, want %d", got, 1+10*3)
+	}
+}
+
+func TestSocketClientReloadsStaleMapReference(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	runtimeMap := server.addMap("rate-limits", "str", MapEntrie{Key: "/api", Value: "10"})
+	client := server.client()
+	ctx := context.Background()
+
+	if _, err := client.GetMapEntrie(ctx, "/api", "rate-limits"); err != nil {
+		t.Fatal(err)
+	}
+	server.moveMap(runtimeMap, "/usr/local/etc/haproxy/maps/rate-limits.map")
+
+	if _, err := client.UpdateMapEntrie(ctx, &MapEntrie{Key: "/api", Value: "20"}, "rate-limits"); err != nil {
+		t.Fatalf("UpdateMapEntrie after the map moved: %v", err)
+	}
+	if got := countExact(server.commands("show map"), "show map"); got != 2 {
+		t.Errorf("the maps were listed %d times, want twice", got)
+	}
+	if got := server.entries(runtimeMap); got[0].Value != "20" {
+		t.Errorf("map entries = %v, want /api updated", got)
+	}
+}
+
+func TestSocketClientGetMapEntrieScansPatternMaps(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	server.addMap("paths", "beg", MapEntrie{Key: "/api", Value: "10"})
+	client := server.client()
+	ctx := context.Background()
+
+	entrie, err := client.GetMapEntrie(ctx, "/api", "paths")
+	if err != nil || entrie.Value != "10" || entrie.Id == "" {
+		t.Fatalf("GetMapEntrie = %v, %v, want the /api entry read from the map", entrie, err)
+	}
+	if _, err := client.GetMapEntrie(ctx, "/missing", "paths"); err != ErrMapEntrieNotFound {
+		t.Errorf("GetMapEntrie of a missing key = %v, want ErrMapEntrieNotFound", err)
+	}
+	if got := len(server.commands("show map /etc/haproxy/maps/paths.map")); got != 2 {
+		t.Errorf("the map was scanned %d times, want twice", got)
+	}
+}
+
+func TestSocketClientEscapesArguments(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	runtimeMap := server.addMap("rate-limits", "str")
+	client := server.client()
+	ctx := context.Background()
+
+	key := `a b;c\d	e`
+	value := `deny; show map`
+	if _, err := client.CreateMapEntrie(ctx, &MapEntrie{Key: key, Value: value}, "rate-limits"); err != nil {
+		t.Fatalf("CreateMapEntrie: %v", err)
+	}
+
+	expected := []MapEntrie{{Key: key, Value: value}}
+	if got := server.entries(runtimeMap); !reflect.DeepEqual(got, expected) {
+		t.Errorf("map entries = %q, want %q", got, expected)
+	}
+}
+
+func TestSocketClientRejectsLineBreaks(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	runtimeMap := server.addMap("rate-limits", "str")
+	client := server.client()
+	ctx := context.Background()
+
+	for _, entrie := range []MapEntrie{
+		{Key: "a\nclear map #1", Value: "1"},
+		{Key: "a", Value: "1\r\nclear map #1"},
+	} {
+		if _, err := client.CreateMapEntrie(ctx, &entrie, "rate-limits"); !errors.Is(err, ErrInvalidMapEntrie) {
+			t.Errorf("CreateMapEntrie(%q) = %v, want ErrInvalidMapEntrie", entrie, err)
+		}
+		if _, err := client.UpdateMapEntrie(ctx, &entrie, "rate-limits"); !errors.Is(err, ErrInvalidMapEntrie) {
+			t.Errorf("UpdateMapEntrie(%q) = %v, want ErrInvalidMapEntrie", entrie, err)
+		}
+	}
+	if err := client.ReplaceMapEntries(ctx, "rate-limits", []MapEntrie{{Key: "a\nb", Value: "1"}}); !errors.Is(err, ErrInvalidMapEntrie) {
+		t.Errorf("ReplaceMapEntries = %v, want ErrInvalidMapEntrie", err)
+	}
+
+	if got := server.commands("clear map"); len(got) != 0 {
+		t.Errorf("commands %q reached the socket", got)
+	}
+	if got := server.entries(runtimeMap); len(got) != 0 {
+		t.Errorf("map entries = %v, want none", got)
+	}
+}
+
+func TestSocketClientReplaceMapEntries(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	runtimeMap := server.addMap("rate-limits", "str", MapEntrie{Key: "/old", Value: "1"})
+	client := server.client()
+	ctx := context.Background()
+
+	entries := []MapEntrie{}
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, MapEntrie{Key: fmt.Sprintf("/path/%d", i), Value: fmt.Sprint(i)})
+	}
+	if err := client.ReplaceMapEntries(ctx, "rate-limits", entries); err != nil {
+		t.Fatalf("ReplaceMapEntries: %v", err)
+	}
+
+	if got := server.entries(runtimeMap); !reflect.DeepEqual(got, entries) {
+		t.Errorf("map entries = %d entries, want the %d replaced ones", len(got), len(entries))
+	}
+	batches := server.commands("add map @1 ")
+	if len(batches) < 2 {
+		t.Errorf("the entries were added in %d batch(es), want several", len(batches))
+	}
+	for _, batch := range batches {
+		if len(batch) > maxCommandLength {
+			t.Errorf("a batch of %d bytes exceeds %d bytes", len(batch), maxCommandLength)
+		}
+	}
+	if got := server.commands("commit map @1 "); len(got) != 1 {
+		t.Errorf("commit commands = %q, want one", got)
+	}
+	if !client.ReplacesAtomically() {
+		t.Error("ReplacesAtomically = false, want true")
+	}
+}
+
+func TestSocketClientCanceledContext(t *testing.T) {
+	server := newFakeRuntimeAPI(t)
+	server.addMap("rate-limits", "str")
+	client := server.client()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := client.GetMapEntries(ctx, "rate-limits"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetMapEntries = %v, want context.Canceled", err)
+	}
+}
+
+func countExact(lines []string, line string) int {
+	count := 0
+	for _, l := range lines {
+		if l == line {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,145 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	storageMapsControllerUrl = "/services/haproxy/storage/maps"
+	rawConfigurationUrl      = "/services/haproxy/configuration/raw"
+)
+
+// CreateMap uploads a new map file holding the given entries to the
+// Dataplane storage. The map is loaded by HAProxy once a configuration
+// referencing it is reloaded. Entries that cannot be written as a single map
+// file line are rejected with ErrInvalidMapEntrie.
+func (c *Client) CreateMap(ctx context.Context, mapName string, entries []MapEntrie) (*StorageMap, error) {
+	content := strings.Builder{}
+	for _, entrie := range entries {
+		if err := ValidateMapFileEntrie(entrie); err != nil {
+			return nil, err
+		}
+		content.WriteString(fmt.Sprintf("%s %s\n", entrie.Key, entrie.Value))
+	}
+
+	storageMap := StorageMap{}
+	resp, err := c.request(ctx, "create_map").
+		SetFileReader("file_upload", mapFileName(mapName), strings.NewReader(content.String())).
+		SetResult(&storageMap).
+		Post(storageMapsControllerUrl)
+
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+		return nil, err
+	}
+
+	if resp.StatusCode() == http.StatusConflict {
+		return nil, ErrMapAlreadyExists
+	}
+
+	if resp.StatusCode() != http.StatusCreated {
+		log.Debug().Msgf("Error while creating map. Status code %d", resp.StatusCode())
+		return nil, newAPIError("creating map", resp)
+	}
+
+	return &storageMap, nil
+}
+
+// ClearMap removes every entry of a runtime map.
+func (c *Client) ClearMap(ctx context.Context, mapName string) error {
+	resp, err := c.request(ctx, "clear_map").
+		Delete(c.routes.ClearMap(mapName))
+
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+		return err
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return ErrMapNotFound
+	}
+
+	if resp.StatusCode() != http.StatusNoContent {
+		log.Debug().Msgf("Error while clearing map. Status code %d", resp.StatusCode())
+		return newAPIError("clearing map", resp)
+	}
+
+	return nil
+}
+
+// DeleteMap deletes a map file from the Dataplane storage.
+func (c *Client) DeleteMap(ctx context.Context, mapName string) error {
+	resp, err := c.request(ctx, "delete_map").
+		Delete(fmt.Sprintf("%s/%s", storageMapsControllerUrl, encodeUrl(mapFileName(mapName))))
+
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+		return err
+	}
+
+	if resp.StatusCode() == http.StatusNotFound {
+		return ErrMapNotFound
+	}
+
+	if resp.StatusCode() != http.StatusNoContent {
+		log.Debug().Msgf("Error while deleting map. Status code %d", resp.StatusCode())
+		return newAPIError("deleting map", resp)
+	}
+
+	return nil
+}
+
+// IsMapReferenced reports whether the running configuration references the
+// map file.
+func (c *Client) IsMapReferenced(ctx context.Context, mapName string) (bool, error) {
+	configuration := rawConfiguration{}
+	resp, err := c.request(ctx, "get_configuration").
+		SetResult(&configuration).
+		Get(rawConfigurationUrl)
+
+	if err != nil {
+		log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+		return false, err
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		log.Debug().Msgf("Error while getting configuration. Status code %d", resp.StatusCode())
+		return false, newAPIError("getting configuration", resp)
+	}
+
+	// The v2 API wraps the configuration in JSON, the v3 API returns it as text.
+	data := configuration.Data
+	if !strings.Contains(resp.Header().Get("Content-Type"), "json") {
+		data = resp.String()
+	}
+
+	reference := regexp.MustCompile(`(^|[\s/(,'"])` + regexp.QuoteMeta(mapFileName(mapName)) + `($|[\s),'"])`)
+	return reference.MatchString(data), nil
+}
+
+// ValidateMapFileEntrie checks that an entry is read back as written from a
+// map file line: the key ends at the first whitespace, the value at the end
+// of the line, and a line starting with '#' is a comment.
+func ValidateMapFileEntrie(entrie MapEntrie) error {
+	switch {
+	case entrie.Key == "":
+		return fmt.Errorf("%w: empty key", ErrInvalidMapEntrie)
+	case strings.ContainsAny(entrie.Key, " \t\r\n\v\f"):
+		return fmt.Errorf("%w: the key %q holds whitespace", ErrInvalidMapEntrie, entrie.Key)
+	case strings.HasPrefix(entrie.Key, "#"):
+		return fmt.Errorf("%w: the key %q starts with '#'", ErrInvalidMapEntrie, entrie.Key)
+	case strings.ContainsAny(entrie.Value, "\r\n"):
+		return fmt.Errorf("%w: the value of %q holds a line break", ErrInvalidMapEntrie, entrie.Key)
+	}
+	return nil
+}
+
+func mapFileName(mapName string) string {
+	return mapName + ".map"
+}
@@ -0,0 +1,158 @@
+package haproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ParseTLSVersion parses a TLS version such as "1.2".
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version '%s'", version)
+	}
+}
+
+// newTLSConfig returns the TLS configuration of the Dataplane connection.
+// The CA bundle and the client certificate are read once here, so that a
+// wrong configuration fails at startup, then reloaded when their files
+// change on disk. The server certificate is checked against the server
+// name, or the host of serverIP when none is configured.
+func newTLSConfig(config TLSConfig, serverIP string) (*tls.Config, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("the client certificate and key must be configured together")
+	}
+
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(serverIP)
+		if err != nil {
+			host = serverIP
+		}
+		config.ServerName = host
+	}
+
+	loader := &certificateLoader{config: config}
+	if err := loader.reload(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: config.ServerName,
+		MinVersion: config.MinVersion,
+	}
+	if config.CertFile != "" {
+		tlsConfig.GetClientCertificate = loader.clientCertificate
+	}
+	if config.CAFile != "" {
+		// The default verification cannot use a CA bundle reloaded after the
+		// configuration is built, so the chain is verified in
+		// VerifyConnection instead.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = loader.verifyConnection
+	}
+	return tlsConfig, nil
+}
+
+// reload reads the files whose modification time changed since the last
+// read, keeping the previous content when they cannot be read.
+func (l *certificateLoader) reload() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.config.CAFile != "" {
+		modTime, err := modificationTime(l.config.CAFile)
+		if err != nil {
+			return err
+		}
+		if !modTime.Equal(l.caModTime) {
+			content, err := os.ReadFile(l.config.CAFile)
+			if err != nil {
+				return err
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(content) {
+				return fmt.Errorf("no certificate found in %s", l.config.CAFile)
+			}
+			l.roots, l.caModTime = roots, modTime
+			log.Info().Msgf("The Dataplane CA bundle %s was loaded.", l.config.CAFile)
+		}
+	}
+
+	if l.config.CertFile != "" {
+		certModTime, err := modificationTime(l.config.CertFile)
+		if err != nil {
+			return err
+		}
+		keyModTime, err := modificationTime(l.config.KeyFile)
+		if err != nil {
+			return err
+		}
+		if !certModTime.Equal(l.certModTime) || !keyModTime.Equal(l.keyModTime) {
+			certificate, err := tls.LoadX509KeyPair(l.config.CertFile, l.config.KeyFile)
+			if err != nil {
+				return err
+			}
+			l.certificate, l.certModTime, l.keyModTime = &certificate, certModTime, keyModTime
+			log.Info().Msgf("The Dataplane client certificate %s was loaded.", l.config.CertFile)
+		}
+	}
+
+	return nil
+}
+
+func (l *certificateLoader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if err := l.reload(); err != nil {
+		log.Warn().Err(err).Msg("The Dataplane client certificate could not be reloaded.")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.certificate, nil
+}
+
+func (l *certificateLoader) verifyConnection(state tls.ConnectionState) error {
+	if err := l.reload(); err != nil {
+		log.Warn().Err(err).Msg("The Dataplane CA bundle could not be reloaded.")
+	}
+
+	l.mu.Lock()
+	roots := l.roots
+	l.mu.Unlock()
+
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("the Dataplane API presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range state.PeerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       l.config.ServerName,
+	})
+	return err
+}
+
+func modificationTime(file string) (time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
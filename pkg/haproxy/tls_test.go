@@ -0,0 +1,276 @@
+package haproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs the certificates of a test server or client.
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		certificate: certificate,
+		key:         key,
+		pem:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a certificate for the given DNS names and IP addresses, and
+// its PEM encoded certificate and key.
+func (ca *testCA) issue(t *testing.T, usage x509.ExtKeyUsage, hosts ...string) (tls.Certificate, []byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate, certPEM, keyPEM
+}
+
+// newTLSServer starts an HTTPS server presenting a certificate issued by ca
+// for hosts. When clientCA is not nil, the server requires a client
+// certificate issued by it.
+func newTLSServer(t *testing.T, ca *testCA, clientCA *testCA, hosts ...string) *httptest.Server {
+	t.Helper()
+
+	certificate, _, _ := ca.issue(t, x509.ExtKeyUsageServerAuth, hosts...)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if clientCA != nil {
+		clientRoots := x509.NewCertPool()
+		clientRoots.AddCert(clientCA.certificate)
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLS.ClientCAs = clientRoots
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// writeFile writes a file with a modification time later than the previous
+// one, so that a reload is detected whatever the file system resolution.
+func writeFile(t *testing.T, file string, content []byte) {
+	t.Helper()
+
+	modTime := time.Now()
+	if info, err := os.Stat(file); err == nil && !modTime.After(info.ModTime()) {
+		modTime = info.ModTime().Add(time.Second)
+	}
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// get sends a request on a new connection, so that each request runs a full
+// handshake.
+func get(t *testing.T, config *tls.Config, server *httptest.Server) error {
+	t.Helper()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestTLSConfigVerifiesServer(t *testing.T) {
+	trustedCA := newTestCA(t, "trusted")
+	untrustedCA := newTestCA(t, "untrusted")
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(t, caFile, trustedCA.pem)
+
+	tests := []struct {
+		name       string
+		serverCA   *testCA
+		hosts      []string
+		serverName string
+		expected   interface{}
+	}{
+		{name: "trusted certificate", serverCA: trustedCA, hosts: []string{"dataplane.test"}, serverName: "dataplane.test"},
+		{name: "address of the host", serverCA: trustedCA, hosts: []string{"127.0.0.1"}},
+		{name: "wrong host name", serverCA: trustedCA, hosts: []string{"dataplane.test"}, serverName: "other.test", expected: x509.HostnameError{}},
+		{name: "wrong address", serverCA: trustedCA, hosts: []string{"10.0.0.1"}, expected: x509.HostnameError{}},
+		{name: "untrusted chain", serverCA: untrustedCA, hosts: []string{"dataplane.test"}, serverName: "dataplane.test", expected: x509.UnknownAuthorityError{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTLSServer(t, test.serverCA, nil, test.hosts...)
+			config, err := newTLSConfig(TLSConfig{CAFile: caFile, ServerName: test.serverName, MinVersion: tls.VersionTLS12}, server.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = get(t, config, server)
+			switch expected := test.expected.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("request failed: %v", err)
+				}
+			case x509.HostnameError:
+				if !errors.As(err, &expected) {
+					t.Errorf("request error = %v, want a host name error", err)
+				}
+			case x509.UnknownAuthorityError:
+				if !errors.As(err, &expected) {
+					t.Errorf("request error = %v, want an unknown authority error", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSConfigReloadsCA(t *testing.T) {
+	oldCA := newTestCA(t, "old")
+	newCA := newTestCA(t, "new")
+	server := newTLSServer(t, newCA, nil, "dataplane.test")
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, caFile, oldCA.pem)
+
+	config, err := newTLSConfig(TLSConfig{CAFile: caFile, ServerName: "dataplane.test"}, server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(t, config, server); err == nil {
+		t.Fatal("request succeeded with the old CA bundle")
+	}
+
+	writeFile(t, caFile, newCA.pem)
+	if err := get(t, config, server); err != nil {
+		t.Errorf("request failed after the CA bundle was renewed: %v", err)
+	}
+
+	// A broken bundle is ignored, the last one loaded stays in use.
+	writeFile(t, caFile, []byte("not a certificate"))
+	if err := get(t, config, server); err != nil {
+		t.Errorf("request failed after the CA bundle was broken: %v", err)
+	}
+}
+
+func TestTLSConfigReloadsClientCertificate(t *testing.T) {
+	serverCA := newTestCA(t, "server")
+	clientCA := newTestCA(t, "client")
+	otherCA := newTestCA(t, "other")
+	server := newTLSServer(t, serverCA, clientCA, "dataplane.test")
+
+	dir := t.TempDir()
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	writeFile(t, caFile, serverCA.pem)
+	_, certPEM, keyPEM := otherCA.issue(t, x509.ExtKeyUsageClientAuth, "mapsyncproxy")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+
+	config, err := newTLSConfig(TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "dataplane.test"}, server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(t, config, server); err == nil {
+		t.Fatal("request succeeded with a client certificate the server does not trust")
+	}
+
+	_, certPEM, keyPEM = clientCA.issue(t, x509.ExtKeyUsageClientAuth, "mapsyncproxy")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	if err := get(t, config, server); err != nil {
+		t.Errorf("request failed after the client certificate was renewed: %v", err)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	writeFile(t, invalidFile, []byte("not a certificate"))
+
+	for name, config := range map[string]TLSConfig{
+		"certificate without key": {CertFile: filepath.Join(dir, "client.pem")},
+		"missing CA bundle":       {CAFile: filepath.Join(dir, "missing.pem")},
+		"invalid CA bundle":       {CAFile: invalidFile},
+	} {
+		if _, err := newTLSConfig(config, "127.0.0.1:5555"); err == nil {
+			t.Errorf("%s: newTLSConfig succeeded", name)
+		}
+	}
+}
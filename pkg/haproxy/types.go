@@ -1,14 +1,182 @@
 package haproxy
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
 	"github.com/go-resty/resty/v2"
 )
 
+// ErrMapEntrieNotFound is returned when a key does not exist in a map.
+var ErrMapEntrieNotFound = errors.New("map entrie not found")
+
+// ErrMapEntrieAlreadyExists is returned when creating a key that already
+// exists in a map.
+var ErrMapEntrieAlreadyExists = errors.New("map entrie already exists")
+
+// ErrInvalidMapEntrie is returned when an entry cannot be written to a map
+// file: its key is empty, holds whitespace or starts a comment, or its value
+// holds a line break.
+var ErrInvalidMapEntrie = errors.New("invalid map entrie")
+
+// ErrMapNotFound is returned when a map does not exist.
+var ErrMapNotFound = errors.New("map not found")
+
+// ErrMapAlreadyExists is returned when creating a map file that already exists.
+var ErrMapAlreadyExists = errors.New("map already exists")
+
+// The kinds of Dataplane API errors, matched by APIError with errors.Is.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrTransient    = errors.New("transient error")
+)
+
+// APIError is an error response of the Dataplane API.
+type APIError struct {
+	Operation  string
+	StatusCode int
+	Status     string
+	// Code and Message are decoded from the response body, when present.
+	Code    int
+	Message string
+}
+
+// MapBackend reads and writes the HAProxy runtime maps. It is implemented by
+// the Dataplane API Client, the Runtime API SocketClient and the in-memory
+// haproxytest.FakeBackend.
+type MapBackend interface {
+	StreamMapEntries(ctx context.Context, mapName string, fn func(MapEntrie) error) error
+	GetMapEntries(ctx context.Context, mapName string) (*[]MapEntrie, error)
+	GetMapEntrie(ctx context.Context, key, mapName string) (*MapEntrie, error)
+	CreateMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error)
+	UpdateMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error)
+	DeleteMapEntrie(ctx context.Context, entrie *MapEntrie, mapName string) (*MapEntrie, error)
+	DeleteMapEntrieById(ctx context.Context, id, mapName string) error
+	// ReplaceMapEntries replaces every entry of a map with the given ones.
+	ReplaceMapEntries(ctx context.Context, mapName string, entries []MapEntrie) error
+	ListMaps(ctx context.Context) (*[]Map, error)
+	GetMap(ctx context.Context, mapName string) (*Map, error)
+	ClearMap(ctx context.Context, mapName string) error
+}
+
+// AtomicReplacer is implemented by the backends whose ReplaceMapEntries swaps
+// every entry at once, so that HAProxy never serves a partially replaced map.
+type AtomicReplacer interface {
+	ReplacesAtomically() bool
+}
+
+// MapStorage manages the map files. The Runtime API SocketClient does not
+// implement it.
+type MapStorage interface {
+	CreateMap(ctx context.Context, mapName string, entries []MapEntrie) (*StorageMap, error)
+	DeleteMap(ctx context.Context, mapName string) error
+	IsMapReferenced(ctx context.Context, mapName string) (bool, error)
+}
+
 type Client struct {
-	username   string
-	password   string
-	serverIP   string
-	HTTPClient *resty.Client
+	credentials Credentials
+	serverIP    string
+	routes      routes
+	observer    RequestObserver
+	HTTPClient  *resty.Client
+}
+
+// RequestObserver is called after each Dataplane API request attempt with the
+// operation, the HTTP status code or "error" when no response was received,
+// and the request latency.
+type RequestObserver func(operation, status string, duration time.Duration)
+
+// operationKey holds the operation name of a request in its context.
+type operationKey struct{}
+
+// observedTransport traces each request attempt and reports it to the
+// RequestObserver of the client.
+type observedTransport struct {
+	base    http.RoundTripper
+	observe func(operation, status string, duration time.Duration)
+}
+
+// routes builds the Dataplane API routes of a version.
+type routes interface {
+	Version() string
+	Maps() string
+	Map(mapName string) string
+	ReplaceEntries(mapName string) string
+	MapEntries(mapName string) string
+	MapEntrie(key, mapName string) string
+	ClearMap(mapName string) string
+}
+
+type v2Routes struct{}
+
+type v3Routes struct{}
+
+type apiInfo struct {
+	API struct {
+		Version string `json:"version"`
+	} `json:"api"`
+}
+
+// Credentials returns the basic auth credentials of a Dataplane API host,
+// given as host:port. It is called before every request with the host the
+// request targets, so that rotated credentials are picked up.
+type Credentials func(host string) (username, password string, err error)
+
+// secretFile caches the content of a file until it changes on disk.
+type secretFile struct {
+	path    string
+	content string
+	modTime time.Time
+	loaded  bool
+	mu      sync.Mutex
+}
+
+// TLSConfig configures the HTTPS connection to the Dataplane API. Empty
+// fields keep the Go defaults: system roots, no client certificate, server
+// name taken from the host.
+type TLSConfig struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	MinVersion uint16
+}
+
+// certificateLoader holds the CA bundle and the client certificate of a
+// TLSConfig, reloaded when their files change on disk.
+type certificateLoader struct {
+	config      TLSConfig
+	roots       *x509.CertPool
+	caModTime   time.Time
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	mu          sync.Mutex
+}
+
+// SocketClient speaks the HAProxy Runtime API over the stats socket.
+type SocketClient struct {
+	network string
+	address string
+	timeout time.Duration
+	// references caches the file of each map.
+	references map[string]string
+	mu         sync.Mutex
+}
+
+// mapLookup is the answer of "get map". Type is the match method of the map.
+type mapLookup struct {
+	Type  string
+	Found bool
+	Key   string
+	Value string
 }
 
 type errorResponse struct {
@@ -20,4 +188,26 @@ type MapEntrie struct {
 	Id    string `json:"id"`
 	Key   string `json:"key"`
 	Value string `json:"value"`
+	// ExpiresAt is only known to mapSyncProxy and never sent to HAProxy.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type Map struct {
+	Description string `json:"description"`
+	File        string `json:"file"`
+	Id          string `json:"id"`
+	Size        int64  `json:"size"`
+}
+
+type StorageMap struct {
+	Description string `json:"description"`
+	File        string `json:"file"`
+	Id          string `json:"id"`
+	StorageName string `json:"storage_name"`
+	Size        int64  `json:"size"`
+}
+
+type rawConfiguration struct {
+	Version int64  `json:"_version"`
+	Data    string `json:"data"`
 }
@@ -2,6 +2,8 @@ package haproxy
 
 import (
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/url"
 )
 
@@ -13,3 +15,27 @@ func generateBasicAuthHeader(username, password string) string {
 func encodeUrl(s string) string {
 	return url.QueryEscape(s)
 }
+
+// expectDelim reads the next JSON token and checks it is the given delimiter.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("Error while decoding mapEntrie: %w", err)
+	}
+	if token != delim {
+		return fmt.Errorf("Error while decoding mapEntrie: expected %s, got %v", delim, token)
+	}
+	return nil
+}
+
+// requestHost returns the host:port a request URL targets, resolved against
+// the base URL of the client when it is relative.
+func requestHost(baseURL, requestURL string) string {
+	if target, err := url.Parse(requestURL); err == nil && target.Host != "" {
+		return target.Host
+	}
+	if base, err := url.Parse(baseURL); err == nil {
+		return base.Host
+	}
+	return ""
+}
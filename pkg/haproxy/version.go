@@ -0,0 +1,114 @@
+package haproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	APIVersion2 = "v2"
+	APIVersion3 = "v3"
+	// APIVersionAuto detects the Dataplane API version through its info endpoint.
+	APIVersionAuto = "auto"
+)
+
+// newRoutes returns the routes of a Dataplane API version.
+func newRoutes(version string) (routes, error) {
+	switch version {
+	case APIVersion2:
+		return v2Routes{}, nil
+	case APIVersion3:
+		return v3Routes{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Dataplane API version '%s'", version)
+	}
+}
+
+// detectAPIVersion queries the info endpoint of each supported version,
+// newest first, and returns the first one answering.
+func (c *Client) detectAPIVersion(baseURL string) (string, error) {
+	for _, version := range []string{APIVersion3, APIVersion2} {
+		info := apiInfo{}
+		resp, err := c.HTTPClient.R().
+			SetResult(&info).
+			Get(fmt.Sprintf("%s/%s/info", baseURL, version))
+
+		if err != nil {
+			log.Debug().Err(err).Msg("Error while calling DataplaneAPI.")
+			return "", err
+		}
+
+		if resp.StatusCode() == http.StatusOK {
+			log.Info().Msgf("Dataplane API %s detected (%s).", version, strings.TrimSpace(info.API.Version))
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("Error while detecting the Dataplane API version: no supported version answered")
+}
+
+func (v2Routes) Version() string {
+	return APIVersion2
+}
+
+func (v2Routes) Maps() string {
+	return "/services/haproxy/runtime/maps"
+}
+
+func (v2Routes) Map(mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps/%s", encodeUrl(mapName))
+}
+
+func (v2Routes) ReplaceEntries(mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps/%s", encodeUrl(mapName))
+}
+
+func (v2Routes) MapEntries(mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps_entries?map=%s", encodeUrl(mapName))
+}
+
+func (v2Routes) MapEntrie(key, mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps_entries/%s?map=%s", encodeUrl(key), encodeUrl(mapName))
+}
+
+func (v2Routes) ClearMap(mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps/%s?forceSync=true", encodeUrl(mapName))
+}
+
+func (v3Routes) Version() string {
+	return APIVersion3
+}
+
+func (v3Routes) Maps() string {
+	return "/services/haproxy/runtime/maps"
+}
+
+func (v3Routes) Map(mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps/%s", encodeUrl(mapName))
+}
+
+func (v3Routes) ReplaceEntries(mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps/%s", encodeUrl(mapName))
+}
+
+func (v3Routes) MapEntries(mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps/%s/entries", encodeUrl(mapName))
+}
+
+func (v3Routes) MapEntrie(key, mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps/%s/entries/%s", encodeUrl(mapName), encodeUrl(key))
+}
+
+func (v3Routes) ClearMap(mapName string) string {
+	return fmt.Sprintf("/services/haproxy/runtime/maps/%s?force_sync=true", encodeUrl(mapName))
+}
+
+// withForceSync asks the Dataplane API to write the change to the map file.
+func withForceSync(route string) string {
+	if strings.Contains(route, "?") {
+		return route + "&force_sync=true"
+	}
+	return route + "?force_sync=true"
+}
@@ -0,0 +1,107 @@
+package haproxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		version        string
+		maps           string
+		runtimeMap     string
+		replaceEntries string
+		mapEntries     string
+		mapEntrie      string
+		clearMap       string
+	}{
+		{
+			version:        APIVersion2,
+			maps:           "/services/haproxy/runtime/maps",
+			runtimeMap:     "/services/haproxy/runtime/maps/rate-limits",
+			replaceEntries: "/services/haproxy/runtime/maps/rate-limits",
+			mapEntries:     "/services/haproxy/runtime/maps_entries?map=rate-limits",
+			mapEntrie:      "/services/haproxy/runtime/maps_entries/%2Fapi%2Fv1?map=rate-limits",
+			clearMap:       "/services/haproxy/runtime/maps/rate-limits?forceSync=true",
+		},
+		{
+			version:        APIVersion3,
+			maps:           "/services/haproxy/runtime/maps",
+			runtimeMap:     "/services/haproxy/runtime/maps/rate-limits",
+			replaceEntries: "/services/haproxy/runtime/maps/rate-limits",
+			mapEntries:     "/services/haproxy/runtime/maps/rate-limits/entries",
+			mapEntrie:      "/services/haproxy/runtime/maps/rate-limits/entries/%2Fapi%2Fv1",
+			clearMap:       "/services/haproxy/runtime/maps/rate-limits?force_sync=true",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			routes, err := newRoutes(test.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := routes.Version(); got != test.version {
+				t.Errorf("Version() = %s, want %s", got, test.version)
+			}
+			if got := routes.Maps(); got != test.maps {
+				t.Errorf("Maps() = %s, want %s", got, test.maps)
+			}
+			if got := routes.Map("rate-limits"); got != test.runtimeMap {
+				t.Errorf("Map() = %s, want %s", got, test.runtimeMap)
+			}
+			if got := routes.ReplaceEntries("rate-limits"); got != test.replaceEntries {
+				t.Errorf("ReplaceEntries() = %s, want %s", got, test.replaceEntries)
+			}
+			if got := routes.MapEntries("rate-limits"); got != test.mapEntries {
+				t.Errorf("MapEntries() = %s, want %s", got, test.mapEntries)
+			}
+			if got := routes.MapEntrie("/api/v1", "rate-limits"); got != test.mapEntrie {
+				t.Errorf("MapEntrie() = %s, want %s", got, test.mapEntrie)
+			}
+			if got := routes.ClearMap("rate-limits"); got != test.clearMap {
+				t.Errorf("ClearMap() = %s, want %s", got, test.clearMap)
+			}
+		})
+	}
+
+	// The v2 routes take the map name in the query.
+	v2 := v2Routes{}
+	if got, expected := v2.MapEntries("a&b=c"), "/services/haproxy/runtime/maps_entries?map=a%26b%3Dc"; got != expected {
+		t.Errorf("MapEntries() = %s, want %s", got, expected)
+	}
+	if got, expected := v2.MapEntrie("/api", "a&b=c"), "/services/haproxy/runtime/maps_entries/%2Fapi?map=a%26b%3Dc"; got != expected {
+		t.Errorf("MapEntrie() = %s, want %s", got, expected)
+	}
+
+	if _, err := newRoutes("v1"); err == nil {
+		t.Error("newRoutes(v1) succeeded")
+	}
+}
+
+func TestDetectAPIVersionUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := listener.Addr().String()
+	listener.Close()
+
+	client := newTestClient(t)
+	client.HTTPClient.SetRetryCount(0)
+	if _, err := client.detectAPIVersion("http://" + host); err == nil {
+		t.Error("detectAPIVersion succeeded without a Dataplane API")
+	}
+}
+
+// newTestClient returns a client of a v2 Dataplane API that is never
+// reached.
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	client, err := NewClient(StaticCredentials("admin", "secret"), "127.0.0.1:0", nil, APIVersion2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
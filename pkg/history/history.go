@@ -0,0 +1,246 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+	"github.com/rs/zerolog/log"
+)
+
+const snapshotSuffix = ".snapshot.json"
+
+// Save assigns the next revision number to the revision, persists it and
+// applies the retention policy.
+func (s *Store) Save(revision *Revision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.revisionFiles(revision.MapName)
+	if err != nil {
+		return err
+	}
+
+	revision.Revision = 1
+	if len(files) > 0 {
+		revision.Revision = files[len(files)-1].revision + 1
+	}
+	if revision.CreatedAt.IsZero() {
+		revision.CreatedAt = time.Now().UTC()
+	}
+
+	// The snapshot is written first, so that a listed revision always has
+	// one.
+	current := revisionFile{
+		name:      revisionFileName(revision.MapName, revision.Revision, revision.CreatedAt),
+		revision:  revision.Revision,
+		createdAt: revision.CreatedAt,
+	}
+	snapshot, err := json.Marshal(revision.Snapshot)
+	if err != nil {
+		return err
+	}
+	if err := s.objects.write(snapshotFileName(current.name), snapshot); err != nil {
+		return err
+	}
+
+	header := *revision
+	header.Snapshot = nil
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if err := s.objects.write(current.name, data); err != nil {
+		return err
+	}
+
+	s.applyRetention(append(files, current))
+	return nil
+}
+
+// List returns the revisions of a map from the oldest to the newest, without
+// their snapshot, which is never read.
+func (s *Store) List(mapName string) ([]Revision, error) {
+	files, err := s.revisionFiles(mapName)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []Revision{}
+	for _, file := range files {
+		revision, err := s.readRevision(file.name)
+		if err != nil {
+			return nil, err
+		}
+		revision.Snapshot = nil
+		revisions = append(revisions, *revision)
+	}
+	return revisions, nil
+}
+
+// Get returns a revision of a map with its snapshot.
+func (s *Store) Get(mapName string, revision int) (*Revision, error) {
+	files, err := s.revisionFiles(mapName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.revision == revision {
+			return s.readRevisionWithSnapshot(file.name)
+		}
+	}
+	return nil, ErrRevisionNotFound
+}
+
+// Entries returns the map entries once the diff of the revision was applied.
+func (r *Revision) Entries() []haproxy.MapEntrie {
+	removed := make(map[string]bool)
+	for _, entrie := range r.Diff.Deleted {
+		removed[entrie.Key] = true
+	}
+	duplicates := make(map[string]bool)
+	for _, entrie := range r.Diff.Duplicates {
+		duplicates[entrie.Id] = true
+	}
+	updated := make(map[string]haproxy.MapEntrie)
+	for _, entrie := range r.Diff.Updated {
+		updated[entrie.Key] = entrie
+	}
+
+	entries := []haproxy.MapEntrie{}
+	for _, entrie := range r.Snapshot {
+		if removed[entrie.Key] || duplicates[entrie.Id] {
+			continue
+		}
+		if update, exists := updated[entrie.Key]; exists {
+			entrie.Value = update.Value
+		}
+		entries = append(entries, entrie)
+	}
+	return append(entries, r.Diff.Created...)
+}
+
+type revisionFile struct {
+	name      string
+	revision  int
+	createdAt time.Time
+}
+
+// revisionFiles returns the revision files of a map sorted by revision.
+func (s *Store) revisionFiles(mapName string) ([]revisionFile, error) {
+	names, err := s.objects.list(mapDirectory(mapName))
+	if err != nil {
+		return nil, err
+	}
+
+	files := []revisionFile{}
+	for _, name := range names {
+		file, ok := parseRevisionFileName(name)
+		if !ok {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].revision < files[j].revision
+	})
+	return files, nil
+}
+
+func (s *Store) readRevision(name string) (*Revision, error) {
+	data, err := s.objects.read(name)
+	if err != nil {
+		return nil, err
+	}
+
+	revision := &Revision{}
+	if err := json.Unmarshal(data, revision); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", name, err)
+	}
+	return revision, nil
+}
+
+// readRevisionWithSnapshot reads a revision and its snapshot.
+func (s *Store) readRevisionWithSnapshot(name string) (*Revision, error) {
+	revision, err := s.readRevision(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.objects.read(snapshotFileName(name))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &revision.Snapshot); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", snapshotFileName(name), err)
+	}
+	return revision, nil
+}
+
+// applyRetention deletes the revisions exceeding the retention policy. Files
+// are sorted by revision and the last one is always kept.
+func (s *Store) applyRetention(files []revisionFile) {
+	now := time.Now()
+	for i, file := range files[:len(files)-1] {
+		tooMany := s.retention.MaxRevisions > 0 && len(files)-i > s.retention.MaxRevisions
+		tooOld := s.retention.MaxAge > 0 && now.Sub(file.createdAt) > s.retention.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := s.objects.delete(file.name); err != nil {
+			log.Warn().Err(err).Msgf("The revision file %s could not be deleted.", file.name)
+			continue
+		}
+		if err := s.objects.delete(snapshotFileName(file.name)); err != nil && !errors.Is(err, errObjectNotFound) {
+			log.Warn().Err(err).Msgf("The revision file %s could not be deleted.", snapshotFileName(file.name))
+		}
+	}
+}
+
+func mapDirectory(mapName string) string {
+	return url.PathEscape(mapName) + "/"
+}
+
+func revisionFileName(mapName string, revision int, createdAt time.Time) string {
+	return fmt.Sprintf("%s%010d-%d.json", mapDirectory(mapName), revision, createdAt.Unix())
+}
+
+// snapshotFileName returns the name of the file holding the snapshot of a
+// revision.
+func snapshotFileName(name string) string {
+	return strings.TrimSuffix(name, ".json") + snapshotSuffix
+}
+
+func parseRevisionFileName(name string) (revisionFile, bool) {
+	if strings.HasSuffix(name, snapshotSuffix) {
+		return revisionFile{}, false
+	}
+	base := strings.TrimSuffix(path.Base(name), ".json")
+	parts := strings.SplitN(base, "-", 2)
+	if len(parts) != 2 {
+		return revisionFile{}, false
+	}
+
+	revision, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return revisionFile{}, false
+	}
+	createdAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return revisionFile{}, false
+	}
+
+	return revisionFile{name: name, revision: revision, createdAt: time.Unix(createdAt, 0)}, true
+}
@@ -0,0 +1,39 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The state files are stored at the root of the store, apart from the map
+// directories holding the revisions.
+
+// SaveState persists a value encoded in JSON under name.
+func (s *Store) SaveState(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.objects.write(stateFileName(name), data)
+}
+
+// LoadState decodes the value persisted under name into v, and leaves v
+// unchanged when nothing was persisted.
+func (s *Store) LoadState(name string, v interface{}) error {
+	data, err := s.objects.read(stateFileName(name))
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("decoding %s: %w", stateFileName(name), err)
+	}
+	return nil
+}
+
+func stateFileName(name string) string {
+	return name + ".state.json"
+}
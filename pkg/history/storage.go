@@ -0,0 +1,118 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"cloud.google.com/go/storage"
+	"github.com/matthisholleville/mapsyncproxy/pkg/gcs"
+)
+
+// NewLocalStore keeps revisions in a local directory.
+func NewLocalStore(dir string, retention Retention) *Store {
+	return &Store{
+		objects:   &localObjectStore{dir: dir},
+		retention: retention,
+	}
+}
+
+// NewGCSStore keeps revisions in a bucket, under an optional prefix.
+func NewGCSStore(client gcs.Storage, bucket, prefix string, retention Retention) *Store {
+	return &Store{
+		objects:   &gcsObjectStore{client: client, bucket: bucket, prefix: prefix},
+		retention: retention,
+	}
+}
+
+func (l *localObjectStore) list(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(l.dir, filepath.FromSlash(prefix)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, path.Join(prefix, entry.Name()))
+		}
+	}
+	return names, nil
+}
+
+func (l *localObjectStore) read(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errObjectNotFound
+	}
+	return data, err
+}
+
+func (l *localObjectStore) write(name string, data []byte) error {
+	file := filepath.Join(l.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	// The state files are overwritten, so that a crash must not leave them
+	// half written.
+	if err := os.WriteFile(file+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+func (l *localObjectStore) delete(name string) error {
+	err := os.Remove(filepath.Join(l.dir, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errObjectNotFound
+	}
+	return err
+}
+
+// The revisions are recorded once the map is changed, so the bucket calls are
+// not bound to the request context: a revision is not lost when the caller
+// disconnects.
+
+func (g *gcsObjectStore) list(prefix string) ([]string, error) {
+	files, err := g.client.ListFiles(context.Background(), g.bucket, g.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range *files {
+		names = append(names, file.Name[len(g.prefix):])
+	}
+	return names, nil
+}
+
+func (g *gcsObjectStore) read(name string) ([]byte, error) {
+	rc, err := g.client.DownloadFile(context.Background(), g.bucket, g.prefix+name, 0)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, errObjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func (g *gcsObjectStore) write(name string, data []byte) error {
+	return g.client.UploadFile(context.Background(), g.bucket, g.prefix+name, "application/json", data)
+}
+
+func (g *gcsObjectStore) delete(name string) error {
+	err := g.client.DeleteFile(context.Background(), g.bucket, g.prefix+name)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return errObjectNotFound
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/matthisholleville/mapsyncproxy/pkg/gcs"
+	"github.com/matthisholleville/mapsyncproxy/pkg/haproxy"
+)
+
+const (
+	OriginSynchronize = "synchronize"
+	OriginRollback    = "rollback"
+)
+
+// ErrRevisionNotFound is returned when a revision does not exist or was
+// removed by the retention policy.
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// ErrSnapshotNotFound is returned when the snapshot of a listed revision is
+// missing, after a partial write or a failed deletion.
+var ErrSnapshotNotFound = errors.New("revision snapshot not found")
+
+// errObjectNotFound is returned by an objectStore reading or deleting a
+// missing object.
+var errObjectNotFound = errors.New("object not found")
+
+type Store struct {
+	objects   objectStore
+	retention Retention
+	mu        sync.Mutex
+}
+
+// Retention bounds the revisions kept per map. Zero values disable the limit.
+// The latest revision is always kept.
+type Retention struct {
+	MaxRevisions int
+	MaxAge       time.Duration
+}
+
+type Revision struct {
+	Revision  int       `json:"revision"`
+	MapName   string    `json:"map_name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Origin is "synchronize" or "rollback".
+	Origin string `json:"origin"`
+	// RollbackOf is the revision restored by a rollback.
+	RollbackOf int `json:"rollback_of,omitempty"`
+	// Snapshot holds the map entries before the diff was applied. It is
+	// stored apart from the other fields, so that listing revisions does not
+	// read it.
+	Snapshot []haproxy.MapEntrie `json:"snapshot,omitempty"`
+	Diff     Diff                `json:"diff"`
+}
+
+type Diff struct {
+	Created []haproxy.MapEntrie `json:"created"`
+	Updated []haproxy.MapEntrie `json:"updated"`
+	Deleted []haproxy.MapEntrie `json:"deleted"`
+	// Duplicates holds the extra occurrences of live keys deleted by id.
+	Duplicates []haproxy.MapEntrie `json:"duplicates,omitempty"`
+}
+
+// objectStore persists revision files under slash separated names.
+type objectStore interface {
+	list(prefix string) ([]string, error)
+	read(name string) ([]byte, error)
+	write(name string, data []byte) error
+	delete(name string) error
+}
+
+type localObjectStore struct {
+	dir string
+}
+
+type gcsObjectStore struct {
+	client gcs.Storage
+	bucket string
+	prefix string
+}
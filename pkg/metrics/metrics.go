@@ -1,43 +1,114 @@
 package metrics
 
 import (
-	"log"
-
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 type ServerMetrics struct {
+	// Registry holds the metrics of the server, the Go runtime and process
+	// collectors, and the HTTP metrics of the Echo middleware.
+	Registry                      *prometheus.Registry
 	MapEntriesTotalCount          *prometheus.CounterVec
 	SynchronizationTotalCount     *prometheus.CounterVec
 	GenerateJsonFromMapTotalCount *prometheus.CounterVec
+	PendingExpirationsCount       *prometheus.GaugeVec
+	SynchronizationDuration       *prometheus.HistogramVec
+	SynchronizationPhaseDuration  *prometheus.HistogramVec
+	DataplaneRequestDuration      *prometheus.HistogramVec
+	LiveMapEntriesCount           *prometheus.GaugeVec
+	DesiredMapEntriesCount        *prometheus.GaugeVec
+	MapDriftCount                 *prometheus.GaugeVec
+	LastSuccessfulSyncTimestamp   *prometheus.GaugeVec
 }
 
+// synchronizationBuckets spread from 50ms to about 7 minutes, as a
+// synchronization of a large map may take minutes.
+var synchronizationBuckets = prometheus.ExponentialBuckets(0.05, 2, 14)
+
+// New registers the server metrics in a registry of their own, so that
+// several servers can live in the same process.
 func New() *ServerMetrics {
-	serverMetrics := &ServerMetrics{}
+	serverMetrics := &ServerMetrics{Registry: prometheus.NewRegistry()}
+	serverMetrics.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
 
-	serverMetrics.MapEntriesTotalCount = createAndRegisterCounter(
+	serverMetrics.MapEntriesTotalCount = serverMetrics.createAndRegisterCounter(
 		"mapsyncproxy_haproxy_mapentries_total",
 		"How many MapEntries processed, partitioned by status and map_name.",
 		[]string{"status", "map_name"},
 	)
 
-	serverMetrics.SynchronizationTotalCount = createAndRegisterCounter(
+	serverMetrics.SynchronizationTotalCount = serverMetrics.createAndRegisterCounter(
 		"mapsyncproxy_synchronization_total",
 		"How many Synchronization processed, partitioned by status and map_name.",
 		[]string{"status", "map_name"},
 	)
 
-	serverMetrics.GenerateJsonFromMapTotalCount = createAndRegisterCounter(
+	serverMetrics.GenerateJsonFromMapTotalCount = serverMetrics.createAndRegisterCounter(
 		"mapsyncproxy_generate_total",
 		"How many GenerateJsonFromMap processed, partitioned by status and map_name.",
 		[]string{"status", "map_name"},
 	)
 
+	serverMetrics.PendingExpirationsCount = serverMetrics.createAndRegisterGauge(
+		"mapsyncproxy_pending_expirations",
+		"How many map entries are waiting for their expiry, partitioned by map_name.",
+		[]string{"map_name"},
+	)
+
+	serverMetrics.SynchronizationDuration = serverMetrics.createAndRegisterHistogram(
+		"mapsyncproxy_synchronization_duration_seconds",
+		"How long Synchronization took end to end, partitioned by status and map_name.",
+		synchronizationBuckets,
+		[]string{"status", "map_name"},
+	)
+
+	serverMetrics.SynchronizationPhaseDuration = serverMetrics.createAndRegisterHistogram(
+		"mapsyncproxy_synchronization_phase_duration_seconds",
+		"How long each Synchronization phase took, partitioned by phase (download, fetch, diff, apply) and map_name.",
+		synchronizationBuckets,
+		[]string{"phase", "map_name"},
+	)
+
+	serverMetrics.DataplaneRequestDuration = serverMetrics.createAndRegisterHistogram(
+		"mapsyncproxy_dataplane_request_duration_seconds",
+		"How long Dataplane API requests took, partitioned by operation and status.",
+		prometheus.DefBuckets,
+		[]string{"operation", "status"},
+	)
+
+	serverMetrics.LiveMapEntriesCount = serverMetrics.createAndRegisterGauge(
+		"mapsyncproxy_map_live_entries",
+		"How many entries the HAProxy map holds, as of the last check, partitioned by map_name.",
+		[]string{"map_name"},
+	)
+
+	serverMetrics.DesiredMapEntriesCount = serverMetrics.createAndRegisterGauge(
+		"mapsyncproxy_map_desired_entries",
+		"How many entries the HAProxy map should hold, as of the last check, partitioned by map_name.",
+		[]string{"map_name"},
+	)
+
+	serverMetrics.MapDriftCount = serverMetrics.createAndRegisterGauge(
+		"mapsyncproxy_map_drift_entries",
+		"How many entries differed between the HAProxy map and the desired entries on the last check, partitioned by map_name.",
+		[]string{"map_name"},
+	)
+
+	serverMetrics.LastSuccessfulSyncTimestamp = serverMetrics.createAndRegisterGauge(
+		"mapsyncproxy_last_successful_synchronization_timestamp_seconds",
+		"When the last successful Synchronization finished, as a Unix timestamp, partitioned by map_name.",
+		[]string{"map_name"},
+	)
+
 	return serverMetrics
 
 }
 
-func createAndRegisterCounter(name, help string, labels []string) *prometheus.CounterVec {
+func (m *ServerMetrics) createAndRegisterCounter(name, help string, labels []string) *prometheus.CounterVec {
 	counter := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: name,
@@ -46,9 +117,36 @@ func createAndRegisterCounter(name, help string, labels []string) *prometheus.Co
 		labels,
 	)
 
-	if err := prometheus.Register(counter); err != nil {
-		log.Fatal(err)
-	}
+	m.Registry.MustRegister(counter)
 
 	return counter
 }
+
+func (m *ServerMetrics) createAndRegisterGauge(name, help string, labels []string) *prometheus.GaugeVec {
+	gauge := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		},
+		labels,
+	)
+
+	m.Registry.MustRegister(gauge)
+
+	return gauge
+}
+
+func (m *ServerMetrics) createAndRegisterHistogram(name, help string, buckets []float64, labels []string) *prometheus.HistogramVec {
+	histogram := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    name,
+			Help:    help,
+			Buckets: buckets,
+		},
+		labels,
+	)
+
+	m.Registry.MustRegister(histogram)
+
+	return histogram
+}
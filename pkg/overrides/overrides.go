@@ -0,0 +1,134 @@
+package overrides
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const stateName = "manual-entries"
+
+func NewRegistry() *Registry {
+	return &Registry{changes: make(map[string]map[string]Change)}
+}
+
+// Persist loads the changes saved in store, then saves them there on every
+// change.
+func (r *Registry) Persist(store StateStore) error {
+	changes := []Change{}
+	if err := store.LoadState(stateName, &changes); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, change := range changes {
+		if _, exists := r.changes[change.MapName]; !exists {
+			r.changes[change.MapName] = make(map[string]Change)
+		}
+		r.changes[change.MapName][change.Key] = change
+	}
+	r.store = store
+	return nil
+}
+
+// Record stores a change. The previous value of an earlier change of the same
+// key is kept, so that an expiry restores the value from before any change.
+func (r *Registry) Record(change Change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now().UTC()
+	}
+
+	mapChanges, exists := r.changes[change.MapName]
+	if !exists {
+		mapChanges = make(map[string]Change)
+		r.changes[change.MapName] = mapChanges
+	}
+	if previous, exists := mapChanges[change.Key]; exists {
+		change.PreviousValue = previous.PreviousValue
+		change.HadPrevious = previous.HadPrevious
+	}
+	mapChanges[change.Key] = change
+	r.save()
+}
+
+// List returns the changes of a map sorted by key.
+func (r *Registry) List(mapName string) []Change {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	changes := []Change{}
+	for _, change := range r.changes[mapName] {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// Expired returns the changes of every map expired at the given time.
+func (r *Registry) Expired(now time.Time) []Change {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	expired := []Change{}
+	for _, mapChanges := range r.changes {
+		for _, change := range mapChanges {
+			if !change.ExpiresAt.IsZero() && !change.ExpiresAt.After(now) {
+				expired = append(expired, change)
+			}
+		}
+	}
+	return expired
+}
+
+// Remove forgets the change of a key.
+func (r *Registry) Remove(mapName, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.changes[mapName][key]; !exists {
+		return
+	}
+	delete(r.changes[mapName], key)
+	if len(r.changes[mapName]) == 0 {
+		delete(r.changes, mapName)
+	}
+	r.save()
+}
+
+// Clear forgets every change of a map.
+func (r *Registry) Clear(mapName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.changes[mapName]; !exists {
+		return
+	}
+	delete(r.changes, mapName)
+	r.save()
+}
+
+// save persists every change when a store is set. It is called with the lock
+// held, so that the saves happen in the order of the changes.
+func (r *Registry) save() {
+	if r.store == nil {
+		return
+	}
+
+	changes := []Change{}
+	for _, mapChanges := range r.changes {
+		for _, change := range mapChanges {
+			changes = append(changes, change)
+		}
+	}
+	if err := r.store.SaveState(stateName, changes); err != nil {
+		log.Warn().Err(err).Msg("The manual changes could not be saved.")
+	}
+}
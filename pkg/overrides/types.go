@@ -0,0 +1,35 @@
+package overrides
+
+import (
+	"sync"
+	"time"
+)
+
+// Change is a map entry set or deleted through the entries API, outside of
+// a synchronization.
+type Change struct {
+	MapName string `json:"map_name"`
+	Key     string `json:"key"`
+	Value   string `json:"value,omitempty"`
+	Deleted bool   `json:"deleted"`
+	// PreviousValue is the value before the first change, restored on expiry
+	// when HadPrevious is true.
+	PreviousValue string    `json:"previous_value,omitempty"`
+	HadPrevious   bool      `json:"had_previous"`
+	Caller        string    `json:"caller"`
+	ChangedAt     time.Time `json:"changed_at"`
+	// ExpiresAt is zero when the change does not expire.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type Registry struct {
+	changes map[string]map[string]Change
+	store   StateStore
+	mu      sync.RWMutex
+}
+
+// StateStore persists the changes, so that their expiry survives a restart.
+type StateStore interface {
+	LoadState(name string, v interface{}) error
+	SaveState(name string, v interface{}) error
+}
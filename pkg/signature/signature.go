@@ -0,0 +1,72 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// NewVerifier loads a PEM encoded public key from a file. Ed25519 keys verify
+// raw signatures, ECDSA keys verify cosign-style signatures over the SHA-256
+// digest of the content.
+func NewVerifier(publicKeyFile string) (*Verifier, error) {
+	data, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading public key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s does not contain a PEM encoded public key", publicKeyFile)
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+
+	switch publicKey.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return &Verifier{publicKey: publicKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+}
+
+// Verify checks a detached signature against the content. The signature may
+// be raw or base64 encoded.
+func (v *Verifier) Verify(content, sig []byte) error {
+	sig = decodeSignature(sig)
+	if len(sig) == 0 {
+		return ErrMissingSignature
+	}
+
+	valid := false
+	switch publicKey := v.publicKey.(type) {
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(publicKey, content, sig)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(content)
+		valid = ecdsa.VerifyASN1(publicKey, digest[:], sig)
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func decodeSignature(sig []byte) []byte {
+	trimmed := bytes.TrimSpace(sig)
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(trimmed)))
+	n, err := base64.StdEncoding.Decode(decoded, trimmed)
+	if err != nil {
+		return sig
+	}
+	return decoded[:n]
+}
@@ -0,0 +1,16 @@
+package signature
+
+import (
+	"crypto"
+	"errors"
+)
+
+// ErrMissingSignature is returned when an object has no detached signature.
+var ErrMissingSignature = errors.New("missing signature")
+
+// ErrInvalidSignature is returned when a signature does not match the content.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+type Verifier struct {
+	publicKey crypto.PublicKey
+}
@@ -0,0 +1,22 @@
+package status
+
+func NewTracker() *Tracker {
+	return &Tracker{statuses: make(map[string]SyncStatus)}
+}
+
+// Set records the last synchronization status of a map.
+func (t *Tracker) Set(mapName string, status SyncStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.statuses[mapName] = status
+}
+
+// Get returns the last synchronization status of a map, if any.
+func (t *Tracker) Get(mapName string) (SyncStatus, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	status, exists := t.statuses[mapName]
+	return status, exists
+}
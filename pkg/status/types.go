@@ -0,0 +1,25 @@
+package status
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusError   = "error"
+)
+
+// SyncStatus is the outcome of the last synchronization of a map.
+type SyncStatus struct {
+	Status     string    `json:"status"`
+	HTTPStatus int       `json:"http_status"`
+	Origin     string    `json:"origin"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+type Tracker struct {
+	statuses map[string]SyncStatus
+	mu       sync.RWMutex
+}
@@ -0,0 +1,21 @@
+package tracing
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Config selects where the spans are exported and how many are kept.
+type Config struct {
+	// Exporter is one of none, otlp or stdout.
+	Exporter string
+	// OTLPEndpoint is the host:port of the OTLP/HTTP collector. The
+	// OTEL_EXPORTER_OTLP_* variables apply when it is empty.
+	OTLPEndpoint string
+	// OTLPInsecure sends the spans over plain HTTP.
+	OTLPInsecure bool
+	// SampleRatio is the share of the traces started by mapSyncProxy that
+	// are recorded. The decision of the caller applies to propagated traces.
+	SampleRatio float64
+}